@@ -0,0 +1,311 @@
+package sqsdr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Operators understood by AttributeChooser
+const (
+	OperatorEqual              = "="
+	OperatorNotEqual           = "!="
+	OperatorMatches            = "=~"
+	OperatorLessThan           = "<"
+	OperatorLessThanOrEqual    = "<="
+	OperatorGreaterThan        = ">"
+	OperatorGreaterThanOrEqual = ">="
+
+	// OperatorOlderThan and OperatorNewerThan treat the attribute as a timestamp in milliseconds since the epoch,
+	// like SentTimestamp, and compare its age against a duration such as 2h
+	OperatorOlderThan = " older-than "
+	OperatorNewerThan = " newer-than "
+)
+
+var (
+	// attributeOperators is ordered so that two character operators win over their one character prefixes
+	attributeOperators = []string{
+		OperatorOlderThan,
+		OperatorNewerThan,
+		OperatorMatches,
+		OperatorNotEqual,
+		OperatorLessThanOrEqual,
+		OperatorGreaterThanOrEqual,
+		OperatorEqual,
+		OperatorLessThan,
+		OperatorGreaterThan,
+	}
+)
+
+// NewAttributeChooser returns an initialized AttributeChooser if the value makes sense for the operator. Regular
+// expressions must compile, numeric comparisons need a number, and age comparisons need a duration like 2h.
+func NewAttributeChooser(name string, operator string, value string) (*AttributeChooser, error) {
+	a := &AttributeChooser{
+		Name:     name,
+		Operator: operator,
+		Value:    value,
+	}
+
+	var err error
+	switch operator {
+	case OperatorEqual, OperatorNotEqual:
+	case OperatorMatches:
+		a.regex, err = regexp.Compile(value)
+	case OperatorLessThan, OperatorLessThanOrEqual, OperatorGreaterThan, OperatorGreaterThanOrEqual:
+		a.number, err = strconv.ParseFloat(value, 64)
+	case OperatorOlderThan, OperatorNewerThan:
+		a.age, err = time.ParseDuration(value)
+	default:
+		return nil, fmt.Errorf("unknown attribute operator '%v'", operator)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse '%v' for attribute %v in NewAttributeChooser: %v", value, name, err)
+	}
+
+	return a, nil
+}
+
+// ParseAttributeExpression returns a Predicate for an expression like error-type=Timeout,
+// ApproximateReceiveCount>=3, error-type=~Time.*, or "SentTimestamp older-than 2h". Comparisons can be combined
+// with and, or, and parentheses where and binds tighter than or, like
+// "(error-type=Timeout or error-type=Throttled) and ApproximateReceiveCount>=3". Values can't contain the words
+// and or or, or unbalanced parentheses.
+func ParseAttributeExpression(expr string) (Predicate, error) {
+	return parseAttributeOr(expr)
+}
+
+func parseAttributeOr(expr string) (Predicate, error) {
+	terms, err := splitAttributeExpression(expr, "or")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(terms) == 1 {
+		return parseAttributeAnd(terms[0])
+	}
+
+	or := &OrChooser{}
+	for _, term := range terms {
+		p, err := parseAttributeAnd(term)
+		if err != nil {
+			return nil, err
+		}
+
+		or.Predicates = append(or.Predicates, p)
+	}
+
+	return or, nil
+}
+
+func parseAttributeAnd(expr string) (Predicate, error) {
+	terms, err := splitAttributeExpression(expr, "and")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(terms) == 1 {
+		return parseAttributeTerm(terms[0])
+	}
+
+	and := &AndChooser{}
+	for _, term := range terms {
+		p, err := parseAttributeTerm(term)
+		if err != nil {
+			return nil, err
+		}
+
+		and.Predicates = append(and.Predicates, p)
+	}
+
+	return and, nil
+}
+
+// parseAttributeTerm parses a parenthesized expression or a single comparison
+func parseAttributeTerm(expr string) (Predicate, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("missing comparison in attribute expression")
+	}
+
+	if strings.HasPrefix(expr, "(") && closingParen(expr) == len(expr)-1 {
+		return parseAttributeOr(expr[1 : len(expr)-1])
+	}
+
+	return parseAttributeComparison(expr)
+}
+
+// parseAttributeComparison returns an AttributeChooser for a single comparison
+func parseAttributeComparison(expr string) (*AttributeChooser, error) {
+	// The first operator in the expression wins so that values are free to contain operators
+	best, bestOp := -1, ""
+	for _, op := range attributeOperators {
+		i := strings.Index(expr, op)
+		if i > 0 && (best < 0 || i < best) {
+			best, bestOp = i, op
+		}
+	}
+
+	if best < 0 {
+		return nil, fmt.Errorf("could not find an operator in attribute expression '%v'", expr)
+	}
+
+	return NewAttributeChooser(
+		strings.TrimSpace(expr[:best]),
+		bestOp,
+		strings.TrimSpace(expr[best+len(bestOp):]),
+	)
+}
+
+// splitAttributeExpression splits expr on the keyword wherever it stands on its own outside of parentheses
+func splitAttributeExpression(expr string, keyword string) ([]string, error) {
+	var (
+		terms []string
+		depth int
+		start int
+	)
+
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unexpected ) in attribute expression '%v'", expr)
+			}
+		}
+
+		if depth == 0 && isAttributeKeyword(expr, i, keyword) {
+			terms = append(terms, expr[start:i])
+			start = i + len(keyword)
+			i = start - 1
+		}
+	}
+
+	if depth > 0 {
+		return nil, fmt.Errorf("missing ) in attribute expression '%v'", expr)
+	}
+
+	return append(terms, expr[start:]), nil
+}
+
+// isAttributeKeyword reports whether the keyword starts at i with a space or the end of expr on either side
+func isAttributeKeyword(expr string, i int, keyword string) bool {
+	if !strings.HasPrefix(expr[i:], keyword) {
+		return false
+	}
+
+	before := i == 0 || expr[i-1] == ' '
+	after := i+len(keyword) == len(expr) || expr[i+len(keyword)] == ' '
+	return before && after
+}
+
+// closingParen returns the index of the parenthesis that closes the one expr starts with or -1
+func closingParen(expr string) int {
+	depth := 0
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// AttributeChooser compares an attribute of each message against a value. Name may be a system attribute such as
+// ApproximateReceiveCount, SentTimestamp, or SenderId, or a custom message attribute. System attributes are
+// checked first. Messages without the attribute never match.
+//
+// Use NewAttributeChooser or ParseAttributeExpression to build one.
+type AttributeChooser struct {
+	Name     string
+	Operator string
+	Value    string
+
+	regex  *regexp.Regexp
+	number float64
+	age    time.Duration
+}
+
+// Choose sends messages whose attribute satisfies the comparison to the left
+func (a *AttributeChooser) Choose(msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	return choose(a, msgs)
+}
+
+// Match reports whether the attribute of the message satisfies the comparison
+func (a *AttributeChooser) Match(msg *sqs.Message) bool {
+	value, ok := attributeValue(msg, a.Name)
+	if !ok {
+		return false
+	}
+
+	switch a.Operator {
+	case OperatorEqual:
+		return value == a.Value
+	case OperatorNotEqual:
+		return value != a.Value
+	case OperatorMatches:
+		return a.regex.MatchString(value)
+	case OperatorLessThan, OperatorLessThanOrEqual, OperatorGreaterThan, OperatorGreaterThanOrEqual:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+
+		return compareNumbers(a.Operator, n, a.number)
+	case OperatorOlderThan, OperatorNewerThan:
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+
+		age := time.Since(time.Unix(0, ms*int64(time.Millisecond)))
+		if a.Operator == OperatorOlderThan {
+			return age > a.age
+		}
+
+		return age < a.age
+	}
+
+	return false
+}
+
+func compareNumbers(operator string, left float64, right float64) bool {
+	switch operator {
+	case OperatorLessThan:
+		return left < right
+	case OperatorLessThanOrEqual:
+		return left <= right
+	case OperatorGreaterThan:
+		return left > right
+	case OperatorGreaterThanOrEqual:
+		return left >= right
+	}
+
+	return false
+}
+
+// attributeValue looks the name up in the system attributes of the message and then in the message attributes.
+// Binary message attributes are never returned.
+func attributeValue(msg *sqs.Message, name string) (string, bool) {
+	if v, ok := msg.Attributes[name]; ok && v != nil {
+		return *v, true
+	}
+
+	if v, ok := msg.MessageAttributes[name]; ok && v != nil && v.StringValue != nil {
+		return *v.StringValue, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,115 @@
+package sqsdr
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// attributeMessage returns a message with the system attributes and String message attributes. Names starting
+// with a capital letter are system attributes.
+func attributeMessage(attrs map[string]string) *sqs.Message {
+	msg := &sqs.Message{
+		Attributes:        make(map[string]*string),
+		MessageAttributes: make(map[string]*sqs.MessageAttributeValue),
+	}
+
+	for name, value := range attrs {
+		if name[0] >= 'A' && name[0] <= 'Z' {
+			msg.Attributes[name] = aws.String(value)
+		} else {
+			msg.MessageAttributes[name] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+	}
+
+	return msg
+}
+
+func millisAgo(d time.Duration) string {
+	return strconv.FormatInt(time.Now().Add(-d).UnixNano()/int64(time.Millisecond), 10)
+}
+
+func TestParseAttributeExpression(t *testing.T) {
+	timeout := attributeMessage(map[string]string{"error-type": "Timeout", "ApproximateReceiveCount": "1", "SentTimestamp": millisAgo(3 * time.Hour)})
+	throttled := attributeMessage(map[string]string{"error-type": "Throttled", "ApproximateReceiveCount": "5", "SentTimestamp": millisAgo(time.Minute)})
+	crashed := attributeMessage(map[string]string{"error-type": "Crashed", "ApproximateReceiveCount": "5", "SentTimestamp": millisAgo(3 * time.Hour)})
+
+	tests := []struct {
+		expr string
+
+		// want is whether timeout, throttled, and crashed match
+		want [3]bool
+	}{
+		{"error-type=Timeout", [3]bool{true, false, false}},
+		{"error-type!=Timeout", [3]bool{false, true, true}},
+		{"error-type=~^T", [3]bool{true, true, false}},
+		{"ApproximateReceiveCount>=5", [3]bool{false, true, true}},
+		{"ApproximateReceiveCount < 5", [3]bool{true, false, false}},
+		{"SentTimestamp older-than 2h", [3]bool{true, false, true}},
+		{"SentTimestamp newer-than 2h", [3]bool{false, true, false}},
+		{"SentTimestamp older-than 90m and error-type=Crashed", [3]bool{false, false, true}},
+
+		// and binds tighter than or
+		{"error-type=Timeout or error-type=Throttled and ApproximateReceiveCount>=5", [3]bool{true, true, false}},
+		{"error-type=Crashed and ApproximateReceiveCount>=5 or error-type=Timeout", [3]bool{true, false, true}},
+
+		// parentheses win over precedence
+		{"(error-type=Timeout or error-type=Crashed) and ApproximateReceiveCount>=5", [3]bool{false, false, true}},
+		{"((error-type=Timeout))", [3]bool{true, false, false}},
+		{"error-type=Throttled or (SentTimestamp older-than 2h and (ApproximateReceiveCount>1))", [3]bool{false, true, true}},
+
+		// parentheses inside a regex are part of the value
+		{"error-type=~(Timeout|Crashed)", [3]bool{true, false, true}},
+
+		// messages without the attribute never match
+		{"unknown=Timeout", [3]bool{false, false, false}},
+		{"Unknown!=Timeout", [3]bool{false, false, false}},
+		{"unknown=x or error-type=Timeout", [3]bool{true, false, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			p, err := ParseAttributeExpression(test.expr)
+			if err != nil {
+				t.Fatalf("ParseAttributeExpression returned an error: %v", err)
+			}
+
+			for i, msg := range []*sqs.Message{timeout, throttled, crashed} {
+				if got := p.Match(msg); got != test.want[i] {
+					t.Errorf("Match(%v) = %v; want %v", aws.StringValue(msg.MessageAttributes["error-type"].StringValue), got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseAttributeExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"empty", ""},
+		{"no operator", "error-type"},
+		{"no name", "=Timeout"},
+		{"bad regex", "error-type=~("},
+		{"bad number", "ApproximateReceiveCount>=three"},
+		{"bad duration", "SentTimestamp older-than soon"},
+		{"missing closing paren", "(error-type=Timeout"},
+		{"missing opening paren", "error-type=Timeout)"},
+		{"dangling and", "error-type=Timeout and"},
+		{"dangling or", "or error-type=Timeout"},
+		{"empty parens", "()"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseAttributeExpression(test.expr)
+			if err == nil {
+				t.Errorf("ParseAttributeExpression(%q) did not return an error", test.expr)
+			}
+		})
+	}
+}
@@ -5,12 +5,18 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"strings"
 
 	"github.com/jmespath/go-jmespath"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
+const (
+	// filterExpressionSeparator splits a JMESPath from a regular expression in ParseFilterExpression
+	filterExpressionSeparator = "=~"
+)
+
 var (
 	emptyMessages = make([]*sqs.Message, 0, 0)
 )
@@ -20,7 +26,7 @@ var (
 // all messages that are passed in are passed back out.
 //
 // By convention the right sink is always the fallthrough sink. Pass any message that
-// don't meet your criteria to the
+// don't meet your criteria to the right.
 type Chooser interface {
 	Choose([]*sqs.Message) ([]*sqs.Message, []*sqs.Message)
 }
@@ -80,58 +86,60 @@ type FilterChooser struct {
 // NOTE: the JMESPath expression must return a valid JSON object. If the output
 // is not valid the SQS message will be put in the right sink.
 func (f *FilterChooser) Choose(msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
-	left := make([]*sqs.Message, 0, len(msgs))
-	right := make([]*sqs.Message, 0, len(msgs))
+	return choose(f, msgs)
+}
 
-	for _, msg := range msgs {
-		body := msg.Body
-		if body == nil {
-			continue
+// Match reports whether the body of a single message satisfies the JMESPath and the regular expression. See
+// Choose for the details.
+func (f *FilterChooser) Match(msg *sqs.Message) bool {
+	body := msg.Body
+	if body == nil {
+		return false
+	}
+	strBody := *body
+
+	// If a JMESPath is provided will make a best effort to run it against the
+	// SQS Body. If an error has occured will throw it in the right sink and continue.
+	//
+	// In the happy path we'll convert whatever JMESPath returned into a string
+	// and let the Regex filter have at it.
+	if f.JMESPath != "" {
+		var jsonBody interface{}
+		jsonErr := json.Unmarshal([]byte(strBody), &jsonBody)
+		// TODO: handle non JSON bodies and fail quietly
+		if jsonErr != nil {
+			log.Printf("could not marshal sqs body into json: %v\n", jsonErr)
+			log.Printf("SQS Body: %v\n", strBody)
+
+			// Wasn't JSON? put in the right sink
+			return false
 		}
-		strBody := *body
-
-		// If a JMESPath is provided will make a best effort to run it against the
-		// SQS Body. If an error has occured will throw it in the right sink and continue.
-		//
-		// In the happy path we'll convert whatever JMESPath returned into a string
-		// and let the Regex filter have at it.
-		if f.JMESPath != "" {
-			var jsonBody interface{}
-			jsonErr := json.Unmarshal([]byte(strBody), &jsonBody)
-			// TODO: handle non JSON bodies and fail quietly
-			if jsonErr != nil {
-				log.Printf("could not marshal sqs body into json: %v\n", jsonErr)
-				log.Printf("SQS Body: %v\n", strBody)
-
-				// Wasn't JSON? put in the right sink
-				right = append(right, msg)
-				continue
-			}
-
-			out, jmesErr := jmespath.Search(f.JMESPath, jsonBody)
-			if jmesErr != nil {
-				log.Printf("jmespath threw an error: %v\n", jmesErr)
-
-				right = append(right, msg)
-				continue
-			}
-
-			b, err := json.Marshal(out)
-			if err != nil {
-				log.Println("could not convert JMES Path output to JSON:", err)
-				right = append(right, msg)
-				continue
-			}
-
-			strBody = string(b)
+
+		out, jmesErr := jmespath.Search(f.JMESPath, jsonBody)
+		if jmesErr != nil {
+			log.Printf("jmespath threw an error: %v\n", jmesErr)
+			return false
 		}
 
-		if f.Regex.MatchString(strBody) {
-			left = append(left, msg)
-		} else {
-			right = append(right, msg)
+		b, err := json.Marshal(out)
+		if err != nil {
+			log.Println("could not convert JMES Path output to JSON:", err)
+			return false
 		}
+
+		strBody = string(b)
+	}
+
+	return f.Regex.MatchString(strBody)
+}
+
+// ParseFilterExpression returns a FilterChooser for an expression of the form <jmespath>=~<regex>. An expression
+// without =~ is treated as a regular expression run against the entire body.
+func ParseFilterExpression(expr string) (*FilterChooser, error) {
+	i := strings.Index(expr, filterExpressionSeparator)
+	if i < 0 {
+		return NewFilterChooser("", expr)
 	}
 
-	return left, right
+	return NewFilterChooser(expr[:i], expr[i+len(filterExpressionSeparator):])
 }
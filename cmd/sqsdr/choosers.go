@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/iamatypeofwalrus/sqsdr"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// buildChooser combines every filtering flag into one Chooser. A message is chosen when it passes the --jmespath
+// and --regex filter, matches at least one --match, matches no --exclude, and satisfies every attribute flag.
+// It returns a nil Chooser when there is nothing to filter on.
+func buildChooser(c *cli.Context) (sqsdr.Chooser, error) {
+	and := &sqsdr.AndChooser{}
+
+	jmesPath := c.String("jmespath")
+	regex := c.String("regex")
+	if regex != "" {
+		log.Printf("\tjmespath: %v\n", jmesPath)
+		log.Printf("\tregex: %v\n", regex)
+
+		filter, err := sqsdr.NewFilterChooser(jmesPath, regex)
+		if err != nil {
+			return nil, err
+		}
+
+		and.Predicates = append(and.Predicates, filter)
+	}
+
+	if matches := c.StringSlice("match"); len(matches) > 0 {
+		log.Printf("\tmatch: %v\n", matches)
+
+		or, err := parseFilterExpressions(matches)
+		if err != nil {
+			return nil, err
+		}
+
+		and.Predicates = append(and.Predicates, or)
+	}
+
+	if excludes := c.StringSlice("exclude"); len(excludes) > 0 {
+		log.Printf("\texclude: %v\n", excludes)
+
+		or, err := parseFilterExpressions(excludes)
+		if err != nil {
+			return nil, err
+		}
+
+		and.Predicates = append(and.Predicates, &sqsdr.NotChooser{Predicate: or})
+	}
+
+	for _, expr := range c.StringSlice("attr") {
+		log.Printf("\tattr: %v\n", expr)
+
+		a, err := sqsdr.ParseAttributeExpression(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		and.Predicates = append(and.Predicates, a)
+	}
+
+	if n := c.Int("min-receive-count"); n > 0 {
+		log.Printf("\tmin receive count: %v\n", n)
+
+		a, err := sqsdr.NewAttributeChooser("ApproximateReceiveCount", sqsdr.OperatorGreaterThanOrEqual, fmt.Sprint(n))
+		if err != nil {
+			return nil, err
+		}
+
+		and.Predicates = append(and.Predicates, a)
+	}
+
+	if d := c.Duration("older-than"); d > 0 {
+		log.Printf("\tolder than: %v\n", d)
+
+		a, err := sqsdr.NewAttributeChooser("SentTimestamp", sqsdr.OperatorOlderThan, d.String())
+		if err != nil {
+			return nil, err
+		}
+
+		and.Predicates = append(and.Predicates, a)
+	}
+
+	if len(and.Predicates) == 0 {
+		return nil, nil
+	}
+
+	return and, nil
+}
+
+func parseFilterExpressions(exprs []string) (*sqsdr.OrChooser, error) {
+	or := &sqsdr.OrChooser{}
+	for _, expr := range exprs {
+		filter, err := sqsdr.ParseFilterExpression(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		or.Predicates = append(or.Predicates, filter)
+	}
+
+	return or, nil
+}
+
+// buildTransformer parses the --transform flag. It returns a nil Transformer when the flag isn't set.
+func buildTransformer(c *cli.Context) (sqsdr.Transformer, error) {
+	expr := c.String("transform")
+	if expr == "" {
+		return nil, nil
+	}
+
+	log.Printf("\ttransform: %v\n", expr)
+	return sqsdr.ParseTransformExpression(expr)
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
-	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/iamatypeofwalrus/sqsdr"
 	cli "gopkg.in/urfave/cli.v1"
 )
@@ -22,36 +22,104 @@ func redrive(c *cli.Context) error {
 		return fmt.Errorf("the destination flag must be present")
 	}
 
-	// Optional
-	jmesPath := c.String("jmespath")
-	regex := c.String("regex")
-
 	// Args with default values
 	region := c.String("region")
+	concurrency := c.Int("concurrency")
+	dryRun := c.Bool("dry-run")
+
+	srcConfig := sqsdr.ClientConfig{
+		Region:   c.String("source-region"),
+		Profile:  c.String("source-profile"),
+		Endpoint: c.GlobalString("endpoint-url"),
+	}
+	if srcConfig.Region == "" {
+		srcConfig.Region = region
+	}
+
+	destConfig := sqsdr.ClientConfig{
+		Region:   c.String("dest-region"),
+		Profile:  c.String("dest-profile"),
+		RoleARN:  c.String("dest-role-arn"),
+		Endpoint: c.GlobalString("endpoint-url"),
+	}
+	if destConfig.Region == "" {
+		destConfig.Region = region
+	}
 
 	log.Println("command: redrive")
 	log.Printf("\tsource: %v\n", src)
 	log.Printf("\tdest: %v\n", dest)
-	log.Printf("\tregion: %v\n", region)
+	log.Printf("\tsource region: %v\n", srcConfig.Region)
+	log.Printf("\tdest region: %v\n", destConfig.Region)
+	if srcConfig.Profile != "" {
+		log.Printf("\tsource profile: %v\n", srcConfig.Profile)
+	}
+	if destConfig.Profile != "" {
+		log.Printf("\tdest profile: %v\n", destConfig.Profile)
+	}
+	if destConfig.RoleARN != "" {
+		log.Printf("\tdest role arn: %v\n", destConfig.RoleARN)
+	}
+	log.Printf("\tconcurrency: %v\n", concurrency)
+	log.Printf("\tdry run: %v\n", dryRun)
+	stop := stopConditions(c)
+	beat := heartbeat(c)
+
+	// Optional
+	chooser, err := buildChooser(c)
+	if err != nil {
+		return err
+	}
+
+	transformer, err := buildTransformer(c)
+	if err != nil {
+		return err
+	}
 
-	if jmesPath != "" {
-		log.Printf("\tjmespath: %v\n", jmesPath)
+	limiter, err := rateLimiter(c)
+	if err != nil {
+		return err
 	}
 
-	if regex != "" {
-		log.Printf("\tregex: %v\n", regex)
+	resolver, err := payloadResolver(c, srcConfig)
+	if err != nil {
+		return err
 	}
 
-	srcClient, srcURL, err := sqsdr.CreateClientAndValidateQueue(region, src)
+	srcClient, srcURL, err := sqsdr.CreateClientAndValidateQueueWithConfig(srcConfig, src)
 	if err != nil {
 		return err
 	}
 
-	destClient, destURL, err := sqsdr.CreateClientAndValidateQueue(region, dest)
+	destClient, destURL, err := sqsdr.CreateClientAndValidateQueueWithConfig(destConfig, dest)
 	if err != nil {
 		return err
 	}
 
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if dryRun {
+		progress := startProgress(c, "", "")
+		defer progress.Stop()
+
+		d := &sqsdr.DryRun{
+			SourceClient:   srcClient,
+			SourceQueueURL: srcURL,
+			Out:            os.Stdout,
+			Chooser:        chooser,
+			Concurrency:    concurrency,
+			Observer:       progress.Observer(),
+			Resolver:       resolver,
+			StopConditions: stop,
+		}
+
+		return d.DryRun(ctx)
+	}
+
+	progress := startProgress(c, "redriven", "kept in source")
+	defer progress.Stop()
+
 	r := &sqsdr.Redrive{
 		SourceClient:   srcClient,
 		SourceQueueURL: srcURL,
@@ -59,11 +127,18 @@ func redrive(c *cli.Context) error {
 		DestClient:   destClient,
 		DestQueueURL: destURL,
 
-		JMESPath: jmesPath,
-		Regex:    regex,
+		Chooser:     chooser,
+		Transformer: transformer,
+		RateLimiter: limiter,
+
+		Concurrency:    concurrency,
+		Observer:       progress.Observer(),
+		Resolver:       resolver,
+		StopConditions: stop,
+		Heartbeat:      beat,
 	}
 
-	return r.Redrive()
+	return r.Redrive(ctx)
 }
 
 func dump(c *cli.Context) error {
@@ -74,23 +149,251 @@ func dump(c *cli.Context) error {
 
 	// Args with default values
 	region := c.String("region")
+	concurrency := c.Int("concurrency")
 
 	log.Println("command: dump")
 	log.Printf("\tsource: %v\n", src)
 	log.Printf("\tregion: %v\n", region)
+	log.Printf("\tconcurrency: %v\n", concurrency)
+	stop := stopConditions(c)
+	beat := heartbeat(c)
+
+	// Optional
+	chooser, err := buildChooser(c)
+	if err != nil {
+		return err
+	}
+
+	transformer, err := buildTransformer(c)
+	if err != nil {
+		return err
+	}
 
-	srcClient, srcURL, err := sqsdr.CreateClientAndValidateQueue(region, src)
+	resolver, err := payloadResolver(c, clientConfig(c, region))
 	if err != nil {
 		return err
 	}
 
+	formatter, err := buildFormatter(c)
+	if err != nil {
+		return err
+	}
+
+	files, err := fileSink(c, formatter, transformer)
+	if err != nil {
+		return err
+	}
+
+	srcClient, srcURL, err := sqsdr.CreateClientAndValidateQueueWithConfig(clientConfig(c, region), src)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	progress := startProgress(c, "", "")
+	defer progress.Stop()
+
 	d := sqsdr.Dump{
 		SourceClient:   srcClient,
 		SourceQueueURL: srcURL,
 		Out:            os.Stdout,
+		Formatter:      formatter,
+		Concurrency:    concurrency,
+		Chooser:        chooser,
+		Transformer:    transformer,
+		Observer:       progress.Observer(),
+		Resolver:       resolver,
+		StopConditions: stop,
+		Heartbeat:      beat,
+	}
+
+	if files == nil {
+		return d.Dump(ctx)
+	}
+
+	files.QueueURL = srcURL
+	d.Output = files
+	err = d.Dump(ctx)
+
+	// The last file is finished even if the dump failed so that what was written can be read
+	closeErr := files.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+func peek(c *cli.Context) error {
+	src := c.String("source")
+	if src == "" {
+		return fmt.Errorf("the source flag must be present")
+	}
+
+	// Args with default values
+	region := c.String("region")
+	concurrency := c.Int("concurrency")
+	visibilityTimeout := c.Int64("visibility-timeout")
+	resetVisibility := c.Bool("reset-visibility")
+
+	log.Println("command: peek")
+	log.Printf("\tsource: %v\n", src)
+	log.Printf("\tregion: %v\n", region)
+	log.Printf("\tconcurrency: %v\n", concurrency)
+	log.Printf("\tvisibility timeout: %v\n", visibilityTimeout)
+	log.Printf("\treset visibility: %v\n", resetVisibility)
+	stop := stopConditions(c)
+
+	formatter, err := buildFormatter(c)
+	if err != nil {
+		return err
+	}
+
+	resolver, err := payloadResolver(c, clientConfig(c, region))
+	if err != nil {
+		return err
+	}
+
+	srcClient, srcURL, err := sqsdr.CreateClientAndValidateQueueWithConfig(clientConfig(c, region), src)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	progress := startProgress(c, "", "")
+	defer progress.Stop()
+
+	p := sqsdr.Peek{
+		SourceClient:      srcClient,
+		SourceQueueURL:    srcURL,
+		Out:               os.Stdout,
+		Formatter:         formatter,
+		VisibilityTimeout: visibilityTimeout,
+		ResetVisibility:   resetVisibility,
+		Concurrency:       concurrency,
+		Observer:          progress.Observer(),
+		Resolver:          resolver,
+		StopConditions:    stop,
+	}
+
+	return p.Peek(ctx)
+}
+
+func stats(c *cli.Context) error {
+	src := c.String("source")
+	if src == "" {
+		return fmt.Errorf("the source flag must be present")
+	}
+
+	// Args with default values
+	region := c.String("region")
+	concurrency := c.Int("concurrency")
+	visibilityTimeout := c.Int64("visibility-timeout")
+	format := c.String("format")
+	top := c.Int("top")
+
+	log.Println("command: stats")
+	log.Printf("\tsource: %v\n", src)
+	log.Printf("\tregion: %v\n", region)
+	log.Printf("\tconcurrency: %v\n", concurrency)
+	log.Printf("\tvisibility timeout: %v\n", visibilityTimeout)
+	log.Printf("\tformat: %v\n", format)
+	log.Printf("\ttop: %v\n", top)
+	stop := stopConditions(c)
+
+	// Optional
+	groupBy := c.String("group-by")
+	groupRegex := c.String("group-regex")
+	signature := c.String("signature")
+	log.Printf("\tgroup by: %v\n", groupBy)
+	log.Printf("\tgroup regex: %v\n", groupRegex)
+	log.Printf("\tsignature: %v\n", signature)
+
+	chooser, err := buildChooser(c)
+	if err != nil {
+		return err
+	}
+
+	resolver, err := payloadResolver(c, clientConfig(c, region))
+	if err != nil {
+		return err
+	}
+
+	srcClient, srcURL, err := sqsdr.CreateClientAndValidateQueueWithConfig(clientConfig(c, region), src)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	progress := startProgress(c, "", "")
+	defer progress.Stop()
+
+	s := sqsdr.Stats{
+		SourceClient:      srcClient,
+		SourceQueueURL:    srcURL,
+		Out:               os.Stdout,
+		Format:            format,
+		Chooser:           chooser,
+		GroupByJMESPath:   groupBy,
+		GroupByRegex:      groupRegex,
+		SignatureJMESPath: signature,
+		TopN:              top,
+		VisibilityTimeout: visibilityTimeout,
+		Concurrency:       concurrency,
+		Observer:          progress.Observer(),
+		Resolver:          resolver,
+		StopConditions:    stop,
+	}
+
+	return s.Stats(ctx)
+}
+
+func recoverFallthrough(c *cli.Context) error {
+	// Optional
+	src := c.String("source")
+
+	// Args with default values
+	region := c.String("region")
+	concurrency := c.Int("concurrency")
+
+	log.Println("command: recover")
+	log.Printf("\tregion: %v\n", region)
+	log.Printf("\tconcurrency: %v\n", concurrency)
+
+	r := sqsdr.Recover{
+		Concurrency: concurrency,
+	}
+
+	if src != "" {
+		log.Printf("\tsource: %v\n", src)
+
+		// The fallthrough queue is in the same region as its source, which may come from a queue URL or ARN
+		client, srcURL, err := sqsdr.CreateClientAndValidateQueueWithConfig(clientConfig(c, region), src)
+		if err != nil {
+			return err
+		}
+
+		r.Client = client
+		r.SourceQueueURL = srcURL
+	} else {
+		client, err := sqsdr.CreateClientWithConfig(clientConfig(c, region))
+		if err != nil {
+			return err
+		}
+
+		r.Client = client
 	}
 
-	return d.Dump()
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	return r.Recover(ctx)
 }
 
 func send(c *cli.Context) error {
@@ -103,37 +406,227 @@ func send(c *cli.Context) error {
 	}
 
 	region := c.String("region")
+	format := c.String("format")
 
 	log.Println("command: send")
 	log.Printf("\tdestination: %v\n", dest)
 	log.Printf("\tregion: %v\n", region)
+	log.Printf("\tformat: %v\n", format)
 
-	srcClient, srcURL, err := sqsdr.CreateClientAndValidateQueue(region, dest)
+	destClient, destURL, err := sqsdr.CreateClientAndValidateQueueWithConfig(clientConfig(c, region), dest)
 	if err != nil {
 		return err
 	}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		rawText := scanner.Text()
+	ctx, cancel := signalContext()
+	defer cancel()
 
-		input := &sqs.SendMessageInput{
-			QueueUrl:    &srcURL,
-			MessageBody: &rawText,
-		}
+	s := sqsdr.Send{
+		DestClient:   destClient,
+		DestQueueURL: destURL,
+		In:           os.Stdin,
+		Format:       format,
+	}
+
+	return s.Send(ctx)
+}
+
+// clientConfig returns the config for a client in the region that honors the global --endpoint-url flag
+func clientConfig(c *cli.Context, region string) sqsdr.ClientConfig {
+	return sqsdr.ClientConfig{
+		Region:   region,
+		Endpoint: c.GlobalString("endpoint-url"),
+	}
+}
+
+// stopConditions reads the flags that stop a command before the source queue is empty
+func stopConditions(c *cli.Context) sqsdr.StopConditions {
+	stop := sqsdr.StopConditions{
+		MaxMessages:       c.Int64("max-messages"),
+		Timeout:           c.Duration("timeout"),
+		StopAfterSnapshot: c.Bool("snapshot"),
+	}
+
+	if stop.MaxMessages > 0 {
+		log.Printf("\tmax messages: %v\n", stop.MaxMessages)
+	}
+
+	if stop.Timeout > 0 {
+		log.Printf("\ttimeout: %v\n", stop.Timeout)
+	}
+
+	if stop.StopAfterSnapshot {
+		log.Printf("\tsnapshot: %v\n", stop.StopAfterSnapshot)
+	}
+
+	return stop
+}
+
+// heartbeat reads the flags that keep the batch in flight hidden while it is handled
+func heartbeat(c *cli.Context) sqsdr.Heartbeat {
+	h := sqsdr.Heartbeat{
+		HeartbeatInterval:     c.Duration("heartbeat"),
+		MaxHeartbeatExtension: c.Duration("heartbeat-max"),
+	}
 
-		output, err := srcClient.SendMessage(input)
+	if h.HeartbeatInterval > 0 {
+		log.Printf("\theartbeat: %v\n", h.HeartbeatInterval)
+	}
+
+	if h.MaxHeartbeatExtension > 0 {
+		log.Printf("\theartbeat max: %v\n", h.MaxHeartbeatExtension)
+	}
+
+	return h
+}
+
+// payloadResolver returns an S3PayloadResolver using the credentials of cfg when --resolve-payloads was passed. It
+// returns nil otherwise.
+func payloadResolver(c *cli.Context, cfg sqsdr.ClientConfig) (sqsdr.PayloadResolver, error) {
+	if !c.Bool("resolve-payloads") {
+		return nil, nil
+	}
+
+	if endpoint := c.GlobalString("s3-endpoint-url"); endpoint != "" {
+		cfg.Endpoint = endpoint
+	}
+
+	log.Printf("\tresolve payloads: true\n")
+	if cfg.Endpoint != "" {
+		log.Printf("\ts3 endpoint: %v\n", cfg.Endpoint)
+	}
+
+	client, err := sqsdr.CreateS3ClientWithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqsdr.S3PayloadResolver{Client: client}, nil
+}
+
+// rateLimiter builds a RateLimiter from --rate and --ramp-up. It returns nil when neither was passed.
+func rateLimiter(c *cli.Context) (*sqsdr.RateLimiter, error) {
+	rate := c.Float64("rate")
+	steps := c.StringSlice("ramp-up")
+	if rate <= 0 && len(steps) == 0 {
+		return nil, nil
+	}
+
+	if rate <= 0 {
+		return nil, fmt.Errorf("the rate flag must be present with ramp-up")
+	}
+
+	limiter := &sqsdr.RateLimiter{Rate: rate}
+	for _, s := range steps {
+		step, err := sqsdr.ParseRateStep(s)
 		if err != nil {
-			log.Println("encountered error while sending message to SQS")
-			return err
+			return nil, err
 		}
 
-		log.Printf("messageId: %v\n", output.MessageId)
+		limiter.RampUp = append(limiter.RampUp, step)
+	}
 
+	log.Printf("\trate: %v\n", rate)
+	for _, step := range limiter.RampUp {
+		log.Printf("\tramp up: %v/s for %v\n", step.Rate, step.Duration)
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+
+	return limiter, nil
+}
+
+// fileSink builds a FileSink from --output and the flags that go with it. It returns nil when --output wasn't passed
+// and an error when one of the flags that go with it was.
+func fileSink(c *cli.Context, formatter sqsdr.Formatter, transformer sqsdr.Transformer) (*sqsdr.FileSink, error) {
+	dir := c.String("output")
+	if dir == "" {
+		for _, name := range []string{"compress", "max-file-size", "max-file-messages"} {
+			if c.IsSet(name) {
+				return nil, fmt.Errorf("the output flag must be present with %v", name)
+			}
+		}
+
+		return nil, nil
+	}
+
+	maxBytes, err := parseByteSize(c.String("max-file-size"))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &sqsdr.FileSink{
+		Dir:         dir,
+		Compression: c.String("compress"),
+		MaxBytes:    maxBytes,
+		MaxMessages: c.Int64("max-file-messages"),
+		Formatter:   formatter,
+		Transformer: transformer,
+	}
+
+	switch f.Compression {
+	case sqsdr.CompressionNone, sqsdr.CompressionGzip, sqsdr.CompressionZstd:
+	default:
+		return nil, fmt.Errorf("unknown compression '%v'. expected one of: gzip, zstd", f.Compression)
+	}
+
+	log.Printf("\toutput: %v\n", f.Dir)
+	log.Printf("\tcompress: %v\n", f.Compression)
+	log.Printf("\tmax file size: %v\n", f.MaxBytes)
+	log.Printf("\tmax file messages: %v\n", f.MaxMessages)
+
+	return f, nil
+}
+
+// parseByteSize parses sizes like 512, 64KB, 100MB, or 1GB. An empty string is 0.
+func parseByteSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		bytes  int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	multiplier := int64(1)
+	number := strings.ToUpper(strings.TrimSpace(size))
+	for _, u := range units {
+		if strings.HasSuffix(number, u.suffix) {
+			multiplier = u.bytes
+			number = strings.TrimSpace(strings.TrimSuffix(number, u.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("could not parse size '%v'. expected something like 100MB", size)
+	}
+
+	return n * multiplier, nil
+}
+
+// buildFormatter builds the Formatter for --format, --attribute, and --column
+func buildFormatter(c *cli.Context) (sqsdr.Formatter, error) {
+	format := c.String("format")
+	attributes := c.StringSlice("attribute")
+	columns := c.StringSlice("column")
+
+	if len(columns) > 0 && format != sqsdr.FormatCSV {
+		return nil, fmt.Errorf("the column flag only works with --format csv")
+	}
+
+	log.Printf("\tformat: %v\n", format)
+	if len(attributes) > 0 {
+		log.Printf("\tattributes: %v\n", attributes)
+	}
+	if len(columns) > 0 {
+		log.Printf("\tcolumns: %v\n", columns)
 	}
 
-	return nil
+	return sqsdr.NewFormatter(format, attributes, columns)
 }
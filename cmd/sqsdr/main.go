@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -26,11 +27,11 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "source, s",
-					Usage: "source queue name (required)",
+					Usage: "source queue name, URL, or ARN (required)",
 				},
 				cli.StringFlag{
 					Name:  "destination, d",
-					Usage: "destination queue name (required)",
+					Usage: "destination queue name, URL, or ARN (required)",
 				},
 				cli.StringFlag{
 					Name:  "regex, x",
@@ -40,11 +41,96 @@ func main() {
 					Name:  "jmespath, j",
 					Usage: "JMESPath expression applied to the message body. output is passed to the regular expression (optional)",
 				},
+				cli.StringSliceFlag{
+					Name:  "match, m",
+					Usage: "redrive messages that match any of these <jmespath>=~<regex> or <regex> expressions. may be repeated (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude, e",
+					Usage: "never redrive messages that match any of these <jmespath>=~<regex> or <regex> expressions. may be repeated (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "attr, a",
+					Usage: "only redrive messages whose attribute satisfies <name><op><value> where op is one of =, !=, =~, <, <=, >, >=, older-than, newer-than. older-than and newer-than compare a timestamp against a duration, e.g. \"SentTimestamp older-than 2h\". comparisons can be combined with and, or, and parentheses. may be repeated (optional)",
+				},
+				cli.IntFlag{
+					Name:  "min-receive-count",
+					Usage: "only redrive messages that have been received at least this many times (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "only redrive messages sent longer ago than this duration, e.g. 2h (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "resolve-payloads",
+					Usage: "fetch bodies that the SQS Extended Client offloaded to S3 so that filters see the real body. redriven messages keep their S3 pointer (default: false)",
+				},
 				cli.StringFlag{
 					Name:  "region, r",
 					Usage: "AWS region of the queues region",
 					Value: "us-east-1",
 				},
+				cli.StringFlag{
+					Name:  "source-region",
+					Usage: "AWS region of the source queue (default: --region)",
+				},
+				cli.StringFlag{
+					Name:  "dest-region",
+					Usage: "AWS region of the destination queue (default: --region)",
+				},
+				cli.StringFlag{
+					Name:  "source-profile",
+					Usage: "AWS shared config profile used for the source queue (optional)",
+				},
+				cli.StringFlag{
+					Name:  "dest-profile",
+					Usage: "AWS shared config profile used for the destination queue (optional)",
+				},
+				cli.StringFlag{
+					Name:  "dest-role-arn",
+					Usage: "IAM role assumed to send messages to a destination queue in another account (optional)",
+				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Usage: "number of workers polling the source queue",
+					Value: 1,
+				},
+				cli.Int64Flag{
+					Name:  "max-messages",
+					Usage: "stop after receiving this many messages (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "stop after running for this long, e.g. 10m (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "snapshot",
+					Usage: "stop after receiving the number of messages that were in the source queue at start (default: false)",
+				},
+				cli.DurationFlag{
+					Name:  "heartbeat",
+					Usage: "extend the visibility timeout of the messages being handled this often, e.g. 30s, so a slow destination doesn't see them redelivered (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "heartbeat-max",
+					Usage: "stop extending the visibility timeout of a batch once this long has passed since it was received, e.g. 1h (optional)",
+				},
+				cli.StringFlag{
+					Name:  "transform, t",
+					Usage: "rewrite message bodies on their way to the destination queue. one of jmespath:<expression>, template:<text/template>, or merge:<JSON merge patch> (optional)",
+				},
+				cli.Float64Flag{
+					Name:  "rate",
+					Usage: "send at most this many messages per second to the destination queue across all workers (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "ramp-up",
+					Usage: "start slower than --rate with <messages per second>:<duration> steps like 10:1m. may be repeated and runs in order (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "report how many messages would be redriven with a sample of each without sending or deleting anything (default: false)",
+				},
 			},
 		},
 		{
@@ -55,13 +141,260 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "source, s",
-					Usage: "source queue name",
+					Usage: "source queue name, URL, or ARN (required)",
+				},
+				cli.StringFlag{
+					Name:  "format, f",
+					Usage: "one of ndjson, json, csv, raw, or table",
+					Value: "ndjson",
+				},
+				cli.StringSliceFlag{
+					Name:  "attribute",
+					Usage: "include this system attribute, e.g. SentTimestamp or ApproximateReceiveCount, with each message. may be repeated (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "column",
+					Usage: "CSV column filled by a JMESPath over the body as <jmespath> or <name>=<jmespath>. may be repeated (optional)",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "write messages to numbered files like dump-0001.ndjson in this directory instead of STDOUT (optional)",
+				},
+				cli.StringFlag{
+					Name:  "compress",
+					Usage: "compress output files with gzip or zstd (optional)",
+				},
+				cli.StringFlag{
+					Name:  "max-file-size",
+					Usage: "start a new output file once the current one holds this much uncompressed data, e.g. 100MB (optional)",
+				},
+				cli.Int64Flag{
+					Name:  "max-file-messages",
+					Usage: "start a new output file once the current one holds this many messages (optional)",
+				},
+				cli.StringFlag{
+					Name:  "transform, t",
+					Usage: "show each message body before and after this transformation without changing the queue. one of jmespath:<expression>, template:<text/template>, or merge:<JSON merge patch> (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "attr, a",
+					Usage: "only dump messages whose attribute satisfies <name><op><value> where op is one of =, !=, =~, <, <=, >, >=, older-than, newer-than. older-than and newer-than compare a timestamp against a duration, e.g. \"SentTimestamp older-than 2h\". comparisons can be combined with and, or, and parentheses. may be repeated (optional)",
+				},
+				cli.IntFlag{
+					Name:  "min-receive-count",
+					Usage: "only dump messages that have been received at least this many times (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "only dump messages sent longer ago than this duration, e.g. 2h (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "resolve-payloads",
+					Usage: "fetch bodies that the SQS Extended Client offloaded to S3 so that filters and the output see the real body (default: false)",
 				},
 				cli.StringFlag{
 					Name:  "region, r",
 					Usage: "AWS region of the queues region",
 					Value: "us-east-1",
 				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Usage: "number of workers polling the source queue",
+					Value: 1,
+				},
+				cli.Int64Flag{
+					Name:  "max-messages",
+					Usage: "stop after receiving this many messages (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "stop after running for this long, e.g. 10m (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "snapshot",
+					Usage: "stop after receiving the number of messages that were in the source queue at start (default: false)",
+				},
+				cli.DurationFlag{
+					Name:  "heartbeat",
+					Usage: "extend the visibility timeout of the messages being handled this often, e.g. 30s, so a slow destination doesn't see them redelivered (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "heartbeat-max",
+					Usage: "stop extending the visibility timeout of a batch once this long has passed since it was received, e.g. 1h (optional)",
+				},
+			},
+		},
+		{
+			Name:    "peek",
+			Aliases: []string{"p"},
+			Usage:   "write messages from a source queue to STDOUT without removing them from the queue",
+			Action:  peek,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "source, s",
+					Usage: "source queue name, URL, or ARN (required)",
+				},
+				cli.StringFlag{
+					Name:  "format, f",
+					Usage: "one of ndjson, json, csv, raw, or table",
+					Value: "ndjson",
+				},
+				cli.StringSliceFlag{
+					Name:  "attribute",
+					Usage: "include this system attribute, e.g. SentTimestamp or ApproximateReceiveCount, with each message. may be repeated (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "column",
+					Usage: "CSV column filled by a JMESPath over the body as <jmespath> or <name>=<jmespath>. may be repeated (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "resolve-payloads",
+					Usage: "fetch bodies that the SQS Extended Client offloaded to S3 so that the output shows the real body (default: false)",
+				},
+				cli.StringFlag{
+					Name:  "region, r",
+					Usage: "AWS region of the queues region",
+					Value: "us-east-1",
+				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Usage: "number of workers polling the source queue",
+					Value: 1,
+				},
+				cli.Int64Flag{
+					Name:  "max-messages",
+					Usage: "stop after receiving this many messages (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "stop after running for this long, e.g. 10m (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "snapshot",
+					Usage: "stop after receiving the number of messages that were in the source queue at start (default: false)",
+				},
+				cli.Int64Flag{
+					Name:  "visibility-timeout, t",
+					Usage: "seconds messages are hidden from other consumers while the queue is read",
+					Value: 900,
+				},
+				cli.BoolFlag{
+					Name:  "reset-visibility",
+					Usage: "make messages visible again once the queue has been read (default: false)",
+				},
+			},
+		},
+		{
+			Name:   "stats",
+			Usage:  "summarize the messages in a source queue without removing them from the queue",
+			Action: stats,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "source, s",
+					Usage: "source queue name, URL, or ARN (required)",
+				},
+				cli.StringFlag{
+					Name:  "format, f",
+					Usage: "one of table or json",
+					Value: "table",
+				},
+				cli.StringFlag{
+					Name:  "group-by",
+					Usage: "count messages by the output of this JMESPath over the body (optional)",
+				},
+				cli.StringFlag{
+					Name:  "group-regex",
+					Usage: "count messages by the first capture group of this regex over the body or the --group-by output (optional)",
+				},
+				cli.StringFlag{
+					Name:  "signature",
+					Usage: "JMESPath that picks the error message out of the body for error signatures. defaults to the whole body (optional)",
+				},
+				cli.IntFlag{
+					Name:  "top",
+					Usage: "number of groups and error signatures to show",
+					Value: 10,
+				},
+				cli.StringFlag{
+					Name:  "regex, x",
+					Usage: "only count message bodies that match the regex (optional)",
+				},
+				cli.StringFlag{
+					Name:  "jmespath, j",
+					Usage: "JMESPath expression applied to the message body. output is passed to the regular expression (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "match, m",
+					Usage: "count messages that match any of these <jmespath>=~<regex> or <regex> expressions. may be repeated (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude, e",
+					Usage: "never count messages that match any of these <jmespath>=~<regex> or <regex> expressions. may be repeated (optional)",
+				},
+				cli.StringSliceFlag{
+					Name:  "attr, a",
+					Usage: "only count messages whose attribute satisfies <name><op><value> where op is one of =, !=, =~, <, <=, >, >=, older-than, newer-than. older-than and newer-than compare a timestamp against a duration, e.g. \"SentTimestamp older-than 2h\". comparisons can be combined with and, or, and parentheses. may be repeated (optional)",
+				},
+				cli.IntFlag{
+					Name:  "min-receive-count",
+					Usage: "only count messages that have been received at least this many times (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "only count messages sent longer ago than this duration, e.g. 2h (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "resolve-payloads",
+					Usage: "fetch bodies that the SQS Extended Client offloaded to S3 so that filters and the report see the real body (default: false)",
+				},
+				cli.StringFlag{
+					Name:  "region, r",
+					Usage: "AWS region of the queues region",
+					Value: "us-east-1",
+				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Usage: "number of workers polling the source queue",
+					Value: 1,
+				},
+				cli.Int64Flag{
+					Name:  "max-messages",
+					Usage: "stop after receiving this many messages (optional)",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "stop after running for this long, e.g. 10m (optional)",
+				},
+				cli.BoolFlag{
+					Name:  "snapshot",
+					Usage: "stop after receiving the number of messages that were in the source queue at start (default: false)",
+				},
+				cli.Int64Flag{
+					Name:  "visibility-timeout, t",
+					Usage: "seconds messages are hidden from other consumers while the queue is read",
+					Value: 900,
+				},
+			},
+		},
+		{
+			Name:   "recover",
+			Usage:  "move messages stranded in temporary fallthrough queues back into their source queue and remove the fallthrough queues",
+			Action: recoverFallthrough,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "source, s",
+					Usage: "only recover the fallthrough queue of this source queue name, URL, or ARN (optional)",
+				},
+				cli.StringFlag{
+					Name:  "region, r",
+					Usage: "AWS region of the queues region",
+					Value: "us-east-1",
+				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Usage: "number of workers polling each fallthrough queue",
+					Value: 1,
+				},
 			},
 		},
 		{
@@ -72,13 +405,18 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "destination, d",
-					Usage: "destination queue name",
+					Usage: "destination queue name, URL, or ARN (required)",
 				},
 				cli.StringFlag{
 					Name:  "region, r",
 					Usage: "AWS region of the queues region",
 					Value: "us-east-1",
 				},
+				cli.StringFlag{
+					Name:  "format, f",
+					Usage: "format of each line: 'raw' sends the line as the message body, 'dump' restores messages written by dump or peek",
+					Value: "raw",
+				},
 			},
 		},
 	}
@@ -88,11 +426,30 @@ func main() {
 			Name:  "loquacious, l",
 			Usage: "log loquaciously (read: verbosely, loudly, a lot) (default: false)",
 		},
+		cli.StringFlag{
+			Name:   "endpoint-url",
+			Usage:  "send requests to an SQS compatible endpoint such as LocalStack or ElasticMQ instead of AWS (optional)",
+			EnvVar: "SQSDR_ENDPOINT,AWS_ENDPOINT_URL",
+		},
+		cli.StringFlag{
+			Name:   "s3-endpoint-url",
+			Usage:  "fetch offloaded payloads from an S3 compatible endpoint such as MinIO. defaults to --endpoint-url (optional)",
+			EnvVar: "SQSDR_S3_ENDPOINT",
+		},
+		cli.BoolFlag{
+			Name:  "no-progress",
+			Usage: "don't show the live progress line or the summary on STDERR (default: false)",
+		},
 	}
 
 	app.Before = setVerboseLogging
 
 	err := app.Run(os.Args)
+	if err == context.Canceled {
+		fmt.Fprintf(os.Stderr, "%v\n", errInterrupted)
+		os.Exit(exitInterrupted)
+	}
+
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iamatypeofwalrus/sqsdr"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+const (
+	progressInterval = 500 * time.Millisecond
+
+	// clearLine is the ANSI escape code that erases the rest of the line
+	clearLine = "\033[K"
+)
+
+// progressReporter draws a live progress line on STDERR while a command runs and writes a summary table when it
+// stops. The live line is only drawn when STDERR is a terminal and --loquacious is off so it doesn't fight with
+// the logs. A nil progressReporter does nothing.
+type progressReporter struct {
+	progress *sqsdr.Progress
+	out      io.Writer
+	live     bool
+
+	// leftLabel and rightLabel name the left and right counts for the command. Empty labels are not shown.
+	leftLabel  string
+	rightLabel string
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startProgress starts reporting progress unless --no-progress was passed
+func startProgress(c *cli.Context, leftLabel string, rightLabel string) *progressReporter {
+	if c.GlobalBool("no-progress") {
+		return nil
+	}
+
+	r := &progressReporter{
+		progress:   &sqsdr.Progress{},
+		out:        os.Stderr,
+		live:       isTerminal(os.Stderr) && !c.GlobalBool("loquacious"),
+		leftLabel:  leftLabel,
+		rightLabel: rightLabel,
+		done:       make(chan struct{}),
+	}
+
+	if r.live {
+		r.wg.Add(1)
+		go r.draw()
+	}
+
+	return r
+}
+
+// Observer returns the Observer to pass to a strategy
+func (r *progressReporter) Observer() sqsdr.Observer {
+	if r == nil {
+		return nil
+	}
+
+	return r.progress
+}
+
+// Stop stops the live line and writes the summary table
+func (r *progressReporter) Stop() {
+	if r == nil {
+		return
+	}
+
+	close(r.done)
+	r.wg.Wait()
+
+	s := r.progress.Snapshot()
+	if r.live {
+		fmt.Fprintf(r.out, "\r%v%v\n", r.line(s), clearLine)
+	}
+
+	r.summary(s)
+}
+
+func (r *progressReporter) draw() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			fmt.Fprintf(r.out, "\r%v%v", r.line(r.progress.Snapshot()), clearLine)
+		}
+	}
+}
+
+func (r *progressReporter) line(s sqsdr.ProgressSnapshot) string {
+	line := fmt.Sprintf("received %v", s.Received)
+	if r.leftLabel != "" {
+		line += fmt.Sprintf(" | %v %v", r.leftLabel, s.Left)
+	}
+	if r.rightLabel != "" {
+		line += fmt.Sprintf(" | %v %v", r.rightLabel, s.Right)
+	}
+	line += fmt.Sprintf(" | deleted %v | failed %v | %.1f msg/s", s.Deleted, s.Failed, s.Throughput)
+
+	if s.ETA > 0 {
+		line += fmt.Sprintf(" | ETA %v", s.ETA.Round(time.Second))
+	}
+
+	return line
+}
+
+func (r *progressReporter) summary(s sqsdr.ProgressSnapshot) {
+	w := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w)
+	if s.ApproximateNumberOfMessages >= 0 {
+		fmt.Fprintf(w, "in queue at start\t%v\n", s.ApproximateNumberOfMessages)
+	}
+	fmt.Fprintf(w, "received\t%v\n", s.Received)
+	if r.leftLabel != "" {
+		fmt.Fprintf(w, "%v\t%v\n", r.leftLabel, s.Left)
+	}
+	if r.rightLabel != "" {
+		fmt.Fprintf(w, "%v\t%v\n", r.rightLabel, s.Right)
+	}
+	fmt.Fprintf(w, "deleted\t%v\n", s.Deleted)
+	fmt.Fprintf(w, "failed\t%v\n", s.Failed)
+	fmt.Fprintf(w, "elapsed\t%v\n", s.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput\t%.1f msg/s\n", s.Throughput)
+
+	w.Flush()
+}
+
+// isTerminal reports whether the file is a character device like a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitInterrupted is the conventional exit code of a process stopped by SIGINT
+const exitInterrupted = 130
+
+// errInterrupted replaces the context error that commands return when they were stopped by a signal
+var errInterrupted = errors.New("interrupted: stopped after the batches in flight")
+
+// signalContext returns a context that is cancelled by the first SIGINT or SIGTERM so that commands can finish the
+// batches in flight and put fallthrough queues back. A second signal exits right away. Call the returned
+// function once the command is done.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+
+		fmt.Fprintln(os.Stderr, "\nstopping after the batches in flight. interrupt again to exit right away")
+		cancel()
+
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+
+		fmt.Fprintln(os.Stderr, "exiting without cleaning up. run `sqsdr recover` to move messages stranded in fallthrough queues back")
+		os.Exit(exitInterrupted)
+	}()
+
+	stop := func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+
+	return ctx, stop
+}
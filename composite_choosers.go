@@ -0,0 +1,132 @@
+package sqsdr
+
+import (
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Predicate reports whether a single SQS message meets some criteria. Every Predicate can be turned into a Chooser
+// with PredicateChooser and combined with other predicates using AndChooser, OrChooser, NotChooser, and RuleChooser.
+// FilterChooser is a Predicate too.
+type Predicate interface {
+	Match(*sqs.Message) bool
+}
+
+// PredicateFunc adapts an ordinary function into a Predicate
+type PredicateFunc func(*sqs.Message) bool
+
+// Match calls the function
+func (p PredicateFunc) Match(msg *sqs.Message) bool {
+	return p(msg)
+}
+
+// PredicateChooser sends messages that match the Predicate to the left and all others to the right
+type PredicateChooser struct {
+	Predicate Predicate
+}
+
+// Choose passes each message through the Predicate
+func (p *PredicateChooser) Choose(msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	return choose(p.Predicate, msgs)
+}
+
+// AndChooser matches messages that match all of its predicates. An AndChooser without predicates matches everything.
+type AndChooser struct {
+	Predicates []Predicate
+}
+
+// Match reports whether every predicate matches the message. It stops at the first predicate that doesn't.
+func (a *AndChooser) Match(msg *sqs.Message) bool {
+	for _, p := range a.Predicates {
+		if !p.Match(msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Choose sends messages that match every predicate to the left
+func (a *AndChooser) Choose(msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	return choose(a, msgs)
+}
+
+// OrChooser matches messages that match any of its predicates. An OrChooser without predicates matches nothing.
+type OrChooser struct {
+	Predicates []Predicate
+}
+
+// Match reports whether any predicate matches the message. It stops at the first predicate that does.
+func (o *OrChooser) Match(msg *sqs.Message) bool {
+	for _, p := range o.Predicates {
+		if p.Match(msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Choose sends messages that match any predicate to the left
+func (o *OrChooser) Choose(msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	return choose(o, msgs)
+}
+
+// NotChooser matches messages that its predicate doesn't
+type NotChooser struct {
+	Predicate Predicate
+}
+
+// Match reports whether the predicate does not match the message
+func (n *NotChooser) Match(msg *sqs.Message) bool {
+	return !n.Predicate.Match(msg)
+}
+
+// Choose sends messages that don't match the predicate to the left
+func (n *NotChooser) Choose(msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	return choose(n, msgs)
+}
+
+// Rule pairs a Predicate with where a matching message should go
+type Rule struct {
+	Predicate Predicate
+	Left      bool
+}
+
+// RuleChooser runs each message through an ordered list of rules. The first rule whose predicate matches decides
+// whether the message goes left or right. Messages that match no rule go left if DefaultLeft is true.
+type RuleChooser struct {
+	Rules       []Rule
+	DefaultLeft bool
+}
+
+// Match reports whether the message should go to the left
+func (r *RuleChooser) Match(msg *sqs.Message) bool {
+	for _, rule := range r.Rules {
+		if rule.Predicate.Match(msg) {
+			return rule.Left
+		}
+	}
+
+	return r.DefaultLeft
+}
+
+// Choose sends messages to the left or the right based on the first matching rule
+func (r *RuleChooser) Choose(msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	return choose(r, msgs)
+}
+
+// choose sends every message that matches the predicate to the left and all others to the right
+func choose(p Predicate, msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	left := make([]*sqs.Message, 0, len(msgs))
+	right := make([]*sqs.Message, 0, len(msgs))
+
+	for _, msg := range msgs {
+		if p.Match(msg) {
+			left = append(left, msg)
+		} else {
+			right = append(right, msg)
+		}
+	}
+
+	return left, right
+}
@@ -0,0 +1,165 @@
+package sqsdr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	defaultDryRunSampleSize = 5
+)
+
+// DryRun reports what a Redrive with the same Chooser would do without doing it. Every message in the source queue
+// is run through the Chooser and a report of the counts and a sample of the matched and unmatched bodies is written
+// to Out. Nothing is sent or deleted and, unlike a filtered Redrive, no fallthrough queue is used. Messages are hidden
+// with a visibility timeout while the queue is read and made visible again at the end.
+type DryRun struct {
+	SourceClient   sqsiface.SQSAPI
+	SourceQueueURL string
+	Out            io.Writer
+
+	// Chooser decides which messages would be redriven. Every message would be redriven when it is nil.
+	Chooser Chooser
+
+	// SampleSize is the number of matched and unmatched bodies to include in the report. Defaults to 5.
+	SampleSize int
+
+	// VisibilityTimeout is the number of seconds messages are hidden for while the queue is read. See Peek.
+	VisibilityTimeout int64
+
+	// Concurrency is the number of workers polling the source queue
+	Concurrency int
+
+	// Observer is optionally told how many messages were received
+	Observer Observer
+
+	// Resolver optionally fetches offloaded bodies so that the Chooser and the samples see the real ones
+	Resolver PayloadResolver
+
+	StopConditions
+}
+
+// DryRun is the entry point into the dry run strategy. Cancelling ctx stops after the batches in flight without
+// writing the report.
+func (d *DryRun) DryRun(ctx context.Context) error {
+	chooser := d.Chooser
+	if chooser == nil {
+		chooser = &PassthroughChooser{}
+	}
+
+	sampleSize := d.SampleSize
+	if sampleSize <= 0 {
+		sampleSize = defaultDryRunSampleSize
+	}
+
+	sink := &dryRunSink{
+		chooser:    chooser,
+		sampleSize: sampleSize,
+	}
+
+	p := &Peek{
+		SourceClient:      d.SourceClient,
+		SourceQueueURL:    d.SourceQueueURL,
+		VisibilityTimeout: d.VisibilityTimeout,
+		ResetVisibility:   true,
+		Concurrency:       d.Concurrency,
+		Observer:          d.Observer,
+		Resolver:          d.Resolver,
+		StopConditions:    d.StopConditions,
+	}
+
+	err := p.peek(ctx, sink)
+	if err != nil {
+		return err
+	}
+
+	return sink.report(d.Out)
+}
+
+// dryRunSink counts the messages the chooser sends left and right and keeps a sample of each
+type dryRunSink struct {
+	chooser    Chooser
+	sampleSize int
+
+	mu              sync.Mutex
+	numMatched      int
+	numUnmatched    int
+	matchedSample   []string
+	unmatchedSample []string
+}
+
+func (d *dryRunSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
+	left, right := d.chooser.Choose(msgs)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.numMatched += len(left)
+	d.numUnmatched += len(right)
+	d.matchedSample = sampleBodies(d.matchedSample, left, d.sampleSize)
+	d.unmatchedSample = sampleBodies(d.unmatchedSample, right, d.sampleSize)
+
+	return nil
+}
+
+func (d *dryRunSink) report(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := fmt.Fprintf(
+		w,
+		"dry run: nothing was sent or deleted\n\nwould redrive: %v\nwould leave in source queue: %v\n",
+		d.numMatched,
+		d.numUnmatched,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = writeSample(w, "sample of messages that would be redriven", d.matchedSample)
+	if err != nil {
+		return err
+	}
+
+	return writeSample(w, "sample of messages that would be left in the source queue", d.unmatchedSample)
+}
+
+// sampleBodies appends message bodies to the sample until it is full
+func sampleBodies(sample []string, msgs []*sqs.Message, size int) []string {
+	for _, msg := range msgs {
+		if len(sample) >= size {
+			break
+		}
+
+		if msg.Body != nil {
+			sample = append(sample, *msg.Body)
+		}
+	}
+
+	return sample
+}
+
+func writeSample(w io.Writer, title string, sample []string) error {
+	if len(sample) == 0 {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(w, "\n%v:\n", title)
+	if err != nil {
+		return err
+	}
+
+	for _, body := range sample {
+		_, err = fmt.Fprintf(w, "%v\n", body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package sqsdr
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+func TestDryRun(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, src, "move-1", "keep-1", "move-2")
+
+	chooser, err := NewFilterChooser("", "move")
+	if err != nil {
+		t.Fatalf("NewFilterChooser returned an error: %v", err)
+	}
+
+	var out bytes.Buffer
+	d := &DryRun{SourceClient: s, SourceQueueURL: src, Out: &out, Chooser: chooser}
+	err = d.DryRun(context.Background())
+	if err != nil {
+		t.Fatalf("DryRun returned an error: %v", err)
+	}
+
+	report := out.String()
+	for _, want := range []string{"would redrive: 2\n", "would leave in source queue: 1\n", "move-1", "keep-1"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q:\n%v", want, report)
+		}
+	}
+
+	// Nothing was sent or deleted and every message is visible again
+	assertBodies(t, s, src, "move-1", "keep-1", "move-2")
+	assertNoFallthroughQueues(t, s)
+
+	resp, err := s.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: aws.String(src), MaxNumberOfMessages: aws.Int64(10)})
+	if err != nil {
+		t.Fatalf("ReceiveMessage returned an error: %v", err)
+	}
+
+	if len(resp.Messages) != 3 {
+		t.Errorf("received %v messages after DryRun; want 3", len(resp.Messages))
+	}
+}
@@ -1,8 +1,10 @@
 package sqsdr
 
 import (
+	"context"
 	"io"
 
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
@@ -12,12 +14,42 @@ type Dump struct {
 	SourceClient   sqsiface.SQSAPI
 	SourceQueueURL string
 	Out            io.Writer
+
+	// Output optionally replaces Out, for example with a FileSink. Every message written to Out would be sunk into
+	// Output instead. Output is responsible for its own Formatter and Transformer.
+	Output Sinker
+
+	// Formatter optionally changes how messages are written to Out. Defaults to an NDJSONFormatter.
+	Formatter Formatter
+
+	// Concurrency is the number of workers polling the source queue
+	Concurrency int
+
+	// Chooser optionally limits the messages written to Out to the ones it sends to the left. Every message
+	// still ends up back in the source queue.
+	Chooser Chooser
+
+	// Transformer optionally shows what a transformation would do. Each message is written with its
+	// transformed Body and its OriginalBody. Messages go back into the source queue untransformed.
+	Transformer Transformer
+
+	// Observer is optionally told how many messages were received and written
+	Observer Observer
+
+	// Resolver optionally fetches offloaded bodies so that the Chooser and Out see the real ones. The messages put
+	// back into the source queue keep their pointers. Messages that can't be resolved are put back without being
+	// written.
+	Resolver PayloadResolver
+
+	StopConditions
+	Heartbeat
 }
 
 // Dump uses a FallthroughPipeline to place all messages in a temporary queue after
 // they've been written to disk. The messages will be placed back into the source
-// queue using a pipline in the reverse direction.
-func (d *Dump) Dump() error {
+// queue using a pipline in the reverse direction. Cancelling ctx stops after the batches in flight and still puts
+// every message back.
+func (d *Dump) Dump(ctx context.Context) error {
 	chooser := &RightPassthroughChooser{}
 	leftSink := &NoOpSink{}
 	rightSinkFunc := func(queueURL string, client sqsiface.SQSAPI) Sinker {
@@ -26,12 +58,27 @@ func (d *Dump) Dump() error {
 			Client:   client,
 		}
 
-		w := &WriterSink{
-			Writer:      d.Out,
-			Passthrough: pass,
+		output := d.Output
+		if output == nil {
+			output = &WriterSink{
+				Writer:      d.Out,
+				Passthrough: NoOpSink{},
+				Formatter:   d.Formatter,
+				Transformer: d.Transformer,
+			}
+		}
+
+		var chooser Chooser = &PassthroughChooser{}
+		if d.Chooser != nil {
+			chooser = d.Chooser
 		}
 
-		return w
+		return &chooserSink{
+			Chooser:     chooser,
+			Left:        output,
+			Passthrough: pass,
+			Resolver:    d.Resolver,
+		}
 	}
 
 	f := &FallthroughPipeline{
@@ -40,7 +87,37 @@ func (d *Dump) Dump() error {
 		RightSinkFunc:  rightSinkFunc,
 		SourceClient:   d.SourceClient,
 		SourceQueueURL: d.SourceQueueURL,
+		Concurrency:    d.Concurrency,
+		Observer:       d.Observer,
+		StopConditions: d.StopConditions,
+		Heartbeat:      d.Heartbeat,
+	}
+
+	return f.Run(ctx)
+}
+
+// chooserSink sinks the messages the Chooser sends to the left into Left and then passes every message
+// through to Passthrough. With a Resolver the Chooser and Left get the real bodies and Passthrough gets the
+// messages as they were received.
+type chooserSink struct {
+	Chooser     Chooser
+	Left        Sinker
+	Passthrough Sinker
+	Resolver    PayloadResolver
+}
+
+func (c *chooserSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
+	// Messages that can't be resolved aren't written but still pass through so that they end up back in the
+	// source queue
+	resolved, _ := resolveMessages(ctx, c.Resolver, msgs)
+
+	left, _ := c.Chooser.Choose(resolved)
+	if len(left) > 0 {
+		err := c.Left.Sink(ctx, left)
+		if err != nil {
+			return err
+		}
 	}
 
-	return f.Run()
+	return c.Passthrough.Sink(ctx, msgs)
 }
@@ -0,0 +1,149 @@
+package sqsdr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+// readDump parses the NDJSON written by Dump or Peek
+func readDump(t *testing.T, out *bytes.Buffer) []MessageOutput {
+	t.Helper()
+
+	var msgs []MessageOutput
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var msg MessageOutput
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", scanner.Text(), err)
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func dumpedBodies(msgs []MessageOutput) []string {
+	bodies := make([]string, len(msgs))
+	for i, msg := range msgs {
+		bodies[i] = aws.StringValue(msg.Body)
+	}
+	sort.Strings(bodies)
+
+	return bodies
+}
+
+func TestDump(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, src, "one", "two", "three")
+
+	var out bytes.Buffer
+	d := &Dump{SourceClient: s, SourceQueueURL: src, Out: &out}
+	err := d.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("Dump returned an error: %v", err)
+	}
+
+	dumped := readDump(t, &out)
+	if got := dumpedBodies(dumped); strings.Join(got, ",") != "one,three,two" {
+		t.Errorf("dumped %v; want one, two, and three", got)
+	}
+
+	for _, msg := range dumped {
+		if msg.MessageId == nil {
+			t.Errorf("dumped %v without a MessageId", aws.StringValue(msg.Body))
+		}
+
+		if msg.ReceiptHandle != nil {
+			t.Errorf("dumped %v with a ReceiptHandle", aws.StringValue(msg.Body))
+		}
+	}
+
+	// Every message is put back
+	assertBodies(t, s, src, "one", "two", "three")
+	assertNoFallthroughQueues(t, s)
+}
+
+func TestDumpWithChooser(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, src, `{"status":"retry"}`, `{"status":"drop"}`)
+
+	chooser, err := NewFilterChooser("status", "retry")
+	if err != nil {
+		t.Fatalf("NewFilterChooser returned an error: %v", err)
+	}
+
+	var out bytes.Buffer
+	d := &Dump{SourceClient: s, SourceQueueURL: src, Out: &out, Chooser: chooser}
+	err = d.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("Dump returned an error: %v", err)
+	}
+
+	if got := dumpedBodies(readDump(t, &out)); len(got) != 1 || got[0] != `{"status":"retry"}` {
+		t.Errorf("dumped %v; want only the retry message", got)
+	}
+
+	assertBodies(t, s, src, `{"status":"retry"}`, `{"status":"drop"}`)
+	assertNoFallthroughQueues(t, s)
+}
+
+func TestDumpWithTransformer(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, src, `{"id":1}`)
+
+	transformer, err := ParseTransformExpression(`merge:{"retried":true}`)
+	if err != nil {
+		t.Fatalf("ParseTransformExpression returned an error: %v", err)
+	}
+
+	var out bytes.Buffer
+	d := &Dump{SourceClient: s, SourceQueueURL: src, Out: &out, Transformer: transformer}
+	err = d.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("Dump returned an error: %v", err)
+	}
+
+	dumped := readDump(t, &out)
+	if len(dumped) != 1 || aws.StringValue(dumped[0].Body) != `{"id":1,"retried":true}` || aws.StringValue(dumped[0].OriginalBody) != `{"id":1}` {
+		t.Errorf("dumped %+v; want the transformed body and the original body", dumped)
+	}
+
+	// The source queue keeps the message as it was
+	assertBodies(t, s, src, `{"id":1}`)
+}
+
+func TestDumpFIFO(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq.fifo")
+	sendBodies(s, src, "one", "two", "three")
+
+	var out bytes.Buffer
+	d := &Dump{SourceClient: s, SourceQueueURL: src, Out: &out}
+	err := d.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("Dump returned an error: %v", err)
+	}
+
+	if got := dumpedBodies(readDump(t, &out)); len(got) != 3 {
+		t.Errorf("dumped %v; want every message", got)
+	}
+
+	if got := queueBodies(s, src); strings.Join(got, ",") != "one,two,three" {
+		t.Errorf("source has %v; want the messages back in their order", got)
+	}
+
+	assertNoFallthroughQueues(t, s)
+}
@@ -5,12 +5,22 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
+const (
+	fallthroughQueuePrefix = "sqsdr-"
+	fallthroughQueueSuffix = "-fallthrough"
+
+	// Tags on the fallthrough queue so that Recover knows where the messages came from
+	sourceQueueURLTag = "sqsdr:source-queue-url"
+	startedAtTag      = "sqsdr:started-at"
+)
+
 // FallthroughPipeline is a higher level pipeline that manages creating fallthrough
 // queues, running the forward pipeline, redriving the messages in the fallthrough
 // queues back into the source queues, and removing the fallthrough queue.
@@ -24,26 +34,59 @@ type FallthroughPipeline struct {
 
 	SourceClient   sqsiface.SQSAPI
 	SourceQueueURL string
+
+	// Transformer optionally rewrites the messages headed for the LeftSink
+	Transformer Transformer
+
+	// Concurrency is the number of workers used by the forward and reverse pollers
+	Concurrency int
+
+	// Observer is optionally told about the progress of the forward pass
+	Observer Observer
+
+	// Resolver optionally fetches offloaded bodies for the Chooser. See Pipeline.
+	Resolver PayloadResolver
+
+	// RateLimiter optionally limits how fast the forward pass receives messages. Messages sent to the right sink
+	// give their tokens back so only the ones headed for the LeftSink count against the rate.
+	RateLimiter *RateLimiter
+
+	// StopConditions only apply to the forward pass. The fallthrough queue is always drained.
+	StopConditions
+
+	// Heartbeat only applies to the forward pass. The reverse pass sinks into SQS which is quick.
+	Heartbeat
 }
 
-// Run is the entrypoint for running the FilterRunner
-func (f *FallthroughPipeline) Run() error {
+// Run is the entrypoint for running the FilterRunner. If the fallthrough queue can't be redriven back into the
+// source queue the returned error explains how to get any stranded messages back.
+//
+// Cancelling ctx or a failure in the forward pass stops the forward pass after the batches in flight. The
+// fallthrough queue is still redriven back into the source queue and removed before that error is returned.
+func (f *FallthroughPipeline) Run(ctx context.Context) error {
 	// Any message that doesn't make it past the filter (i.e. ends up in the right sink)
 	// will end up in this queue. At the end of the function we'll put messages
 	// in this queue back into the source queue, and then delete this queue.
 	fallthroughQueueURL, err := createFallthroughQueue(
 		f.SourceClient,
 		f.SourceQueueURL,
+		time.Now(),
 	)
 	if err != nil {
 		return err
 	}
 
 	rightSink := f.RightSinkFunc(fallthroughQueueURL, f.SourceClient)
+	if f.RateLimiter != nil {
+		rightSink = &rateReturnSink{Limiter: f.RateLimiter, Passthrough: rightSink}
+	}
 	pipeline := &Pipeline{
-		Chooser:   f.Chooser,
-		LeftSink:  f.LeftSink,
-		RightSink: rightSink,
+		Chooser:     f.Chooser,
+		LeftSink:    f.LeftSink,
+		RightSink:   rightSink,
+		Transformer: f.Transformer,
+		Observer:    f.Observer,
+		Resolver:    f.Resolver,
 	}
 
 	// Run filter over all messages in the source queue. If messages pass the filter successfully
@@ -51,18 +94,50 @@ func (f *FallthroughPipeline) Run() error {
 	// we just created)
 	log.Println("passing messages from source queue through filter")
 	poller := NewPoller(f.SourceQueueURL, f.SourceClient, pipeline)
-	err = poller.Process(context.Background())
-	if err != nil {
-		return err
+	poller.Concurrency = f.Concurrency
+	poller.StopConditions = f.StopConditions
+	poller.Heartbeat = f.Heartbeat
+	poller.Observer = f.Observer
+	poller.RateLimiter = f.RateLimiter
+	interrupted := poller.Process(ctx)
+	if interrupted != nil && interrupted == ctx.Err() {
+		log.Println("interrupted: putting the fallthrough queue back into the source before stopping")
+	} else if interrupted != nil {
+		log.Println("forward pass failed: putting the fallthrough queue back into the source before stopping:", interrupted)
 	}
 
 	// Now we have a whole bunch of messages in the right sink and we need to put
-	// them back in the source
+	// them back in the source. This has to happen even if the forward pass was interrupted or failed.
 	log.Println("redriving messages that ended up in the temporary fallthrough queue back to the source")
+	err = redriveFallthroughQueue(uncancelable(ctx), f.SourceClient, fallthroughQueueURL, f.SourceQueueURL, f.Concurrency)
+	if err != nil {
+		if interrupted != nil {
+			err = fmt.Errorf("%v\nthen could not redrive the fallthrough queue: %v", interrupted, err)
+		}
+
+		return recoveryError(err, fallthroughQueueURL, f.SourceQueueURL)
+	}
+
+	// Huzzah! Let's remove the queue that we created at the top of the function
+	log.Println("removing temporary fallthrough queue", fallthroughQueueURL)
+	err = deleteFallthroughQueue(f.SourceClient, fallthroughQueueURL)
+	if err != nil {
+		return err
+	}
+
+	return interrupted
+}
+
+// redriveFallthroughQueue moves every message in the fallthrough queue back into the source queue
+func redriveFallthroughQueue(ctx context.Context, client sqsiface.SQSAPI, fallthroughQueueURL string, sourceQueueURL string, concurrency int) error {
 	passthrough := &PassthroughChooser{}
 	sourceSink := &SQSSink{
-		QueueURL: f.SourceQueueURL,
-		Client:   f.SourceClient,
+		QueueURL: sourceQueueURL,
+		Client:   client,
+
+		// The original deduplication IDs were used on the source queue moments ago. Reusing them would have a FIFO
+		// queue silently drop every message inside of the deduplication window.
+		RegenerateDeduplicationID: true,
 	}
 
 	reversePipeline := &Pipeline{
@@ -71,24 +146,36 @@ func (f *FallthroughPipeline) Run() error {
 		RightSink: &NoOpSink{},
 	}
 
-	rightPoller := NewPoller(fallthroughQueueURL, f.SourceClient, reversePipeline)
-	err = rightPoller.Process(context.Background())
-	if err != nil {
-		return err
-	}
+	rightPoller := NewPoller(fallthroughQueueURL, client, reversePipeline)
+	rightPoller.Concurrency = concurrency
+	return rightPoller.Process(ctx)
+}
 
-	// Huzzah! Let's remove the queue that we created at the top of the function
-	log.Println("removing temporary fallthrough queue", fallthroughQueueURL)
-	return deleteFallthroughQueue(f.SourceClient, fallthroughQueueURL)
+// recoveryError wraps an error that happened while the fallthrough queue may still hold messages with instructions
+// for getting them back into the source queue
+func recoveryError(err error, fallthroughQueueURL string, sourceQueueURL string) error {
+	return fmt.Errorf(
+		"%v\n\nmessages may be stranded in the temporary fallthrough queue %v\n"+
+			"run `sqsdr recover --source %v` with the same region and credentials to move them back into the source queue and remove the fallthrough queue",
+		err,
+		fallthroughQueueURL,
+		queueNameFromURL(sourceQueueURL),
+	)
 }
 
-func createFallthroughQueue(client sqsiface.SQSAPI, queueURL string) (string, error) {
-	// this is _super_ gross. ¯\_(ツ)_/¯
-	split := strings.Split(queueURL, "/")
-	queueName := split[len(split)-1]
-	name := aws.String(fmt.Sprintf("sqsdr-%v-fallthrough", queueName))
+// createFallthroughQueue creates the temporary queue for the right sink. If the source queue is a FIFO queue the
+// fallthrough queue will be one too so that each message group keeps its order on the way out and back.
+//
+// The queue is tagged with the source queue URL and the start time so that Recover can put stranded messages
+// back where they came from.
+func createFallthroughQueue(client sqsiface.SQSAPI, queueURL string, startedAt time.Time) (string, error) {
+	req := &sqs.CreateQueueInput{QueueName: aws.String(fallthroughQueueName(queueURL))}
+	if isFIFOQueue(queueURL) {
+		req.Attributes = map[string]*string{
+			sqs.QueueAttributeNameFifoQueue: aws.String("true"),
+		}
+	}
 
-	req := &sqs.CreateQueueInput{QueueName: name}
 	// NOTE: if the queue already exists SQS is more than happy to return a successful
 	//       response. Nice!
 	resp, err := client.CreateQueue(req)
@@ -96,9 +183,52 @@ func createFallthroughQueue(client sqsiface.SQSAPI, queueURL string) (string, er
 		return "", fmt.Errorf("could not create queue for filter sink: %v", err)
 	}
 
+	_, err = client.TagQueue(&sqs.TagQueueInput{
+		QueueUrl: resp.QueueUrl,
+		Tags: map[string]*string{
+			sourceQueueURLTag: aws.String(queueURL),
+			startedAtTag:      aws.String(startedAt.UTC().Format(time.RFC3339)),
+		},
+	})
+	if err != nil {
+		// Recover can still work out the source queue from the name of the fallthrough queue
+		log.Println("could not tag fallthrough queue:", err)
+	}
+
 	return *resp.QueueUrl, nil
 }
 
+// fallthroughQueueName returns sqsdr-<queue name>-fallthrough with the .fifo suffix moved to the end for FIFO queues
+func fallthroughQueueName(queueURL string) string {
+	queueName := queueNameFromURL(queueURL)
+	if isFIFOQueue(queueURL) {
+		return fallthroughQueuePrefix + strings.TrimSuffix(queueName, fifoSuffix) + fallthroughQueueSuffix + fifoSuffix
+	}
+
+	return fallthroughQueuePrefix + queueName + fallthroughQueueSuffix
+}
+
+// isFallthroughQueue reports whether the queue was created by createFallthroughQueue
+func isFallthroughQueue(queueURL string) bool {
+	queueName := strings.TrimSuffix(queueNameFromURL(queueURL), fifoSuffix)
+	return strings.HasPrefix(queueName, fallthroughQueuePrefix) && strings.HasSuffix(queueName, fallthroughQueueSuffix)
+}
+
+// sourceQueueNameFromFallthrough is the inverse of fallthroughQueueName
+func sourceQueueNameFromFallthrough(queueURL string) string {
+	queueName := queueNameFromURL(queueURL)
+	fifo := isFIFOQueue(queueURL)
+
+	queueName = strings.TrimSuffix(queueName, fifoSuffix)
+	queueName = strings.TrimPrefix(queueName, fallthroughQueuePrefix)
+	queueName = strings.TrimSuffix(queueName, fallthroughQueueSuffix)
+	if fifo {
+		queueName += fifoSuffix
+	}
+
+	return queueName
+}
+
 func deleteFallthroughQueue(client sqsiface.SQSAPI, queueURL string) error {
 	req := &sqs.DeleteQueueInput{QueueUrl: aws.String(queueURL)}
 	_, err := client.DeleteQueue(req)
@@ -0,0 +1,333 @@
+package sqsdr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats understood by FileSink
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+const (
+	defaultFilePrefix = "dump"
+	manifestSuffix    = ".manifest.json"
+)
+
+// FileSink writes messages with a Formatter to numbered files in Dir like dump-0001.ndjson.gz. A new file
+// is started once the current one reaches MaxBytes or MaxMessages. Every file gets a manifest next to it, like
+// dump-0001.ndjson.gz.manifest.json, with the queue URL, the message count, and the range of SentTimestamps in the
+// file. It is safe to use from multiple Poller workers.
+//
+// Call Close once the Poller is done to finish the last file and write its manifest.
+type FileSink struct {
+	Dir string
+
+	// Prefix is the start of every file name. Defaults to dump.
+	Prefix string
+
+	// Compression is one of CompressionNone, CompressionGzip, or CompressionZstd
+	Compression string
+
+	// MaxBytes rotates files once they hold this many bytes before compression. Zero means no limit.
+	MaxBytes int64
+
+	// MaxMessages rotates files once they hold this many messages. Zero means no limit.
+	MaxMessages int64
+
+	// QueueURL is recorded in the manifest of each file
+	QueueURL string
+
+	// Formatter optionally changes how messages are written. Each file starts with its Header and is named with its
+	// Extension. Defaults to an NDJSONFormatter.
+	Formatter Formatter
+
+	// Transformer optionally previews a transformation. See WriterSink.
+	Transformer Transformer
+
+	mu      sync.Mutex
+	file    *dumpFile
+	fileNum int
+}
+
+// FileManifest describes a file written by FileSink
+type FileManifest struct {
+	File        string
+	QueueURL    string `json:",omitempty"`
+	Compression string `json:",omitempty"`
+
+	MessageCount int64
+
+	// Bytes is the size of the file before compression
+	Bytes int64
+
+	// OldestMessage and NewestMessage are the earliest and latest SentTimestamp of the messages in the file
+	OldestMessage *time.Time `json:",omitempty"`
+	NewestMessage *time.Time `json:",omitempty"`
+
+	CreatedAt time.Time
+	ClosedAt  time.Time
+}
+
+// dumpFile is the file FileSink is writing to
+type dumpFile struct {
+	path       string
+	file       *os.File
+	compressor io.WriteCloser
+	w          io.Writer
+	manifest   FileManifest
+}
+
+// Sink writes the messages to the current file, rotating as it goes
+func (f *FileSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	formatter := f.formatter()
+
+	for _, msg := range msgs {
+		var buf bytes.Buffer
+		err := formatter.Format(&buf, newMessageOutput(msg, f.Transformer))
+		if err != nil {
+			return fmt.Errorf("could not format message %v: %v", aws.StringValue(msg.MessageId), err)
+		}
+
+		if f.file == nil {
+			err = f.open(formatter)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = f.file.w.Write(buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("could not write to %v: %v", f.file.path, err)
+		}
+		f.file.record(msg, buf.Len())
+
+		if f.full() {
+			err = f.close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close finishes the current file and writes its manifest
+func (f *FileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	return f.close()
+}
+
+// full reports whether the current file has reached one of the limits. Callers must hold the lock.
+func (f *FileSink) full() bool {
+	m := f.file.manifest
+	return (f.MaxBytes > 0 && m.Bytes >= f.MaxBytes) || (f.MaxMessages > 0 && m.MessageCount >= f.MaxMessages)
+}
+
+func (f *FileSink) formatter() Formatter {
+	if f.Formatter == nil {
+		return &NDJSONFormatter{}
+	}
+
+	return f.Formatter
+}
+
+// open starts the next file with the header of the formatter. Existing files are never overwritten. Callers must
+// hold the lock.
+func (f *FileSink) open(formatter Formatter) error {
+	prefix := f.Prefix
+	if prefix == "" {
+		prefix = defaultFilePrefix
+	}
+
+	ext, err := compressionExtension(f.Compression)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(f.Dir, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create output directory %v: %v", f.Dir, err)
+	}
+
+	f.fileNum++
+	name := fmt.Sprintf("%v-%04d%v%v", prefix, f.fileNum, formatter.Extension(), ext)
+	path := filepath.Join(f.Dir, name)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create output file: %v", err)
+	}
+
+	d := &dumpFile{
+		path: path,
+		file: file,
+		w:    file,
+		manifest: FileManifest{
+			File:        name,
+			QueueURL:    f.QueueURL,
+			Compression: f.Compression,
+			CreatedAt:   time.Now().UTC(),
+		},
+	}
+
+	switch f.Compression {
+	case CompressionGzip:
+		d.compressor = gzip.NewWriter(file)
+	case CompressionZstd:
+		d.compressor, err = zstd.NewWriter(file)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("could not start zstd compression: %v", err)
+		}
+	}
+
+	if d.compressor != nil {
+		d.w = d.compressor
+	}
+
+	f.file = d
+
+	var buf bytes.Buffer
+	err = formatter.Header(&buf)
+	if err != nil {
+		return fmt.Errorf("could not write header to %v: %v", path, err)
+	}
+
+	_, err = d.w.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("could not write header to %v: %v", path, err)
+	}
+	d.manifest.Bytes += int64(buf.Len())
+
+	return nil
+}
+
+// close flushes the current file and writes its manifest. Callers must hold the lock.
+func (f *FileSink) close() error {
+	d := f.file
+	f.file = nil
+
+	if d.compressor != nil {
+		err := d.compressor.Close()
+		if err != nil {
+			d.file.Close()
+			return fmt.Errorf("could not finish compressing %v: %v", d.path, err)
+		}
+	}
+
+	err := d.file.Close()
+	if err != nil {
+		return fmt.Errorf("could not close %v: %v", d.path, err)
+	}
+
+	d.manifest.ClosedAt = time.Now().UTC()
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(d.manifest)
+	if err != nil {
+		return fmt.Errorf("could not convert manifest for %v to JSON: %v", d.path, err)
+	}
+
+	err = writeFileExclusive(d.path+manifestSuffix, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("could not write manifest for %v: %v", d.path, err)
+	}
+
+	return nil
+}
+
+// record adds a message to the manifest
+func (d *dumpFile) record(msg *sqs.Message, n int) {
+	d.manifest.MessageCount++
+	d.manifest.Bytes += int64(n)
+
+	sent, ok := sentTimestamp(msg)
+	if !ok {
+		return
+	}
+
+	if d.manifest.OldestMessage == nil || sent.Before(*d.manifest.OldestMessage) {
+		d.manifest.OldestMessage = &sent
+	}
+
+	if d.manifest.NewestMessage == nil || sent.After(*d.manifest.NewestMessage) {
+		d.manifest.NewestMessage = &sent
+	}
+}
+
+// sentTimestamp parses the SentTimestamp system attribute of the message
+func sentTimestamp(msg *sqs.Message) (time.Time, bool) {
+	v, ok := msg.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]
+	if !ok || v == nil {
+		return time.Time{}, false
+	}
+
+	ms, err := strconv.ParseInt(*v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC(), true
+}
+
+func compressionExtension(compression string) (string, error) {
+	switch compression {
+	case CompressionNone:
+		return "", nil
+	case CompressionGzip:
+		return ".gz", nil
+	case CompressionZstd:
+		return ".zst", nil
+	}
+
+	return "", fmt.Errorf(
+		"unknown compression '%v'. expected one of: %v, %v",
+		compression,
+		CompressionGzip,
+		CompressionZstd,
+	)
+}
+
+func writeFileExclusive(path string, b []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(b)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,305 @@
+package sqsdr
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/klauspost/compress/zstd"
+)
+
+// tempDir returns a new directory for FileSink. The caller removes it.
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "sqsdr-file-sink")
+	if err != nil {
+		t.Fatalf("could not create a temporary directory: %v", err)
+	}
+
+	return dir
+}
+
+// sentMessages returns n messages with bodies like msg-0 sent a second apart starting at sentAt
+func sentMessages(n int, sentAt time.Time) []*sqs.Message {
+	msgs := make([]*sqs.Message, n)
+	for i := range msgs {
+		ms := sentAt.Add(time.Duration(i)*time.Second).UnixNano() / int64(time.Millisecond)
+		msgs[i] = &sqs.Message{
+			MessageId:  aws.String(strconv.Itoa(i)),
+			Body:       aws.String("msg-" + strconv.Itoa(i)),
+			Attributes: map[string]*string{sqs.MessageSystemAttributeNameSentTimestamp: aws.String(strconv.FormatInt(ms, 10))},
+		}
+	}
+
+	return msgs
+}
+
+// readDumpFile decompresses the file and returns the body of every NDJSON line in it
+func readDumpFile(t *testing.T, path string, compression string) []string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open %v: %v", path, err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("%v is not gzip: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	case CompressionZstd:
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			t.Fatalf("%v is not zstd: %v", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var bodies []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var msg MessageOutput
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			t.Fatalf("could not parse %q in %v: %v", scanner.Text(), path, err)
+		}
+
+		bodies = append(bodies, aws.StringValue(msg.Body))
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("could not read %v: %v", path, err)
+	}
+
+	return bodies
+}
+
+func readManifest(t *testing.T, path string) FileManifest {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path + manifestSuffix)
+	if err != nil {
+		t.Fatalf("could not read the manifest of %v: %v", path, err)
+	}
+
+	var m FileManifest
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		t.Fatalf("could not parse the manifest of %v: %v", path, err)
+	}
+
+	return m
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	// Every line is the same length so the byte limit lands on a message boundary
+	line := `{"Body":"msg-0","MessageId":"0"}` + "\n"
+
+	tests := []struct {
+		name        string
+		compression string
+		maxBytes    int64
+		maxMessages int64
+		wantFiles   map[string][]string
+	}{
+		{
+			name:      "no limits",
+			wantFiles: map[string][]string{"dump-0001.ndjson": {"msg-0", "msg-1", "msg-2", "msg-3", "msg-4"}},
+		},
+		{
+			name:        "by messages",
+			maxMessages: 2,
+			wantFiles: map[string][]string{
+				"dump-0001.ndjson": {"msg-0", "msg-1"},
+				"dump-0002.ndjson": {"msg-2", "msg-3"},
+				"dump-0003.ndjson": {"msg-4"},
+			},
+		},
+		{
+			name:     "by bytes",
+			maxBytes: int64(3 * len(line)),
+			wantFiles: map[string][]string{
+				"dump-0001.ndjson": {"msg-0", "msg-1", "msg-2"},
+				"dump-0002.ndjson": {"msg-3", "msg-4"},
+			},
+		},
+		{
+			name:        "gzip",
+			compression: CompressionGzip,
+			maxMessages: 3,
+			wantFiles: map[string][]string{
+				"dump-0001.ndjson.gz": {"msg-0", "msg-1", "msg-2"},
+				"dump-0002.ndjson.gz": {"msg-3", "msg-4"},
+			},
+		},
+		{
+			name:        "zstd",
+			compression: CompressionZstd,
+			maxMessages: 3,
+			wantFiles: map[string][]string{
+				"dump-0001.ndjson.zst": {"msg-0", "msg-1", "msg-2"},
+				"dump-0002.ndjson.zst": {"msg-3", "msg-4"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			f := &FileSink{Dir: dir, Compression: test.compression, MaxBytes: test.maxBytes, MaxMessages: test.maxMessages}
+
+			msgs := sentMessages(5, time.Now())
+			err := f.Sink(context.Background(), msgs[:2])
+			if err != nil {
+				t.Fatalf("Sink returned an error: %v", err)
+			}
+
+			err = f.Sink(context.Background(), msgs[2:])
+			if err != nil {
+				t.Fatalf("Sink returned an error: %v", err)
+			}
+
+			err = f.Close()
+			if err != nil {
+				t.Fatalf("Close returned an error: %v", err)
+			}
+
+			got := make(map[string][]string)
+			for name := range test.wantFiles {
+				got[name] = readDumpFile(t, filepath.Join(dir, name), test.compression)
+			}
+
+			if !reflect.DeepEqual(got, test.wantFiles) {
+				t.Errorf("files = %v; want %v", got, test.wantFiles)
+			}
+
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("could not list %v: %v", dir, err)
+			}
+
+			// Every file has a manifest and there are no others
+			if len(entries) != 2*len(test.wantFiles) {
+				t.Errorf("%v has %v files; want %v and their manifests", dir, len(entries), len(test.wantFiles))
+			}
+		})
+	}
+}
+
+func TestFileSinkManifest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789012/orders-dlq"
+	f := &FileSink{Dir: dir, Compression: CompressionGzip, MaxMessages: 3, QueueURL: queueURL}
+
+	sentAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	msgs := sentMessages(5, sentAt)
+
+	// Messages don't arrive in the order they were sent
+	msgs[0], msgs[2] = msgs[2], msgs[0]
+
+	start := time.Now().UTC()
+	err := f.Sink(context.Background(), msgs)
+	if err != nil {
+		t.Fatalf("Sink returned an error: %v", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		count  int64
+		oldest time.Time
+		newest time.Time
+		bodies []string
+	}{
+		{"dump-0001.ndjson.gz", 3, sentAt, sentAt.Add(2 * time.Second), []string{"msg-2", "msg-1", "msg-0"}},
+		{"dump-0002.ndjson.gz", 2, sentAt.Add(3 * time.Second), sentAt.Add(4 * time.Second), []string{"msg-3", "msg-4"}},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		m := readManifest(t, path)
+
+		if m.File != test.name || m.QueueURL != queueURL || m.Compression != CompressionGzip {
+			t.Errorf("%v manifest is for %v of %v with %q compression", test.name, m.File, m.QueueURL, m.Compression)
+		}
+
+		if m.MessageCount != test.count {
+			t.Errorf("%v manifest counts %v messages; want %v", test.name, m.MessageCount, test.count)
+		}
+
+		if m.OldestMessage == nil || !m.OldestMessage.Equal(test.oldest) {
+			t.Errorf("%v oldest message = %v; want %v", test.name, m.OldestMessage, test.oldest)
+		}
+
+		if m.NewestMessage == nil || !m.NewestMessage.Equal(test.newest) {
+			t.Errorf("%v newest message = %v; want %v", test.name, m.NewestMessage, test.newest)
+		}
+
+		if m.CreatedAt.Before(start.Add(-time.Second)) || m.ClosedAt.Before(m.CreatedAt) {
+			t.Errorf("%v was created at %v and closed at %v", test.name, m.CreatedAt, m.ClosedAt)
+		}
+
+		bodies := readDumpFile(t, path, CompressionGzip)
+		if !reflect.DeepEqual(bodies, test.bodies) {
+			t.Errorf("%v has %v; want %v", test.name, bodies, test.bodies)
+		}
+
+		// Bytes counts what was written before compression
+		size := 0
+		for _, body := range bodies {
+			line, _ := json.Marshal(MessageOutput{Body: aws.String(body), MessageId: aws.String(body[len("msg-"):])})
+			size += len(line) + 1
+		}
+		if m.Bytes != int64(size) {
+			t.Errorf("%v manifest has %v bytes; want %v", test.name, m.Bytes, size)
+		}
+	}
+}
+
+func TestFileSinkNeverOverwrites(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	err := ioutil.WriteFile(filepath.Join(dir, "dump-0001.ndjson"), []byte("keep me\n"), 0644)
+	if err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+
+	f := &FileSink{Dir: dir}
+	err = f.Sink(context.Background(), sentMessages(1, time.Now()))
+	if err == nil {
+		t.Fatal("Sink overwrote an existing file")
+	}
+
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "dump-0001.ndjson"))
+	if string(b) != "keep me\n" {
+		t.Errorf("existing file has %q", b)
+	}
+}
@@ -0,0 +1,359 @@
+package sqsdr
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/jmespath/go-jmespath"
+)
+
+// Formats understood by NewFormatter
+const (
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatRaw    = "raw"
+	FormatTable  = "table"
+)
+
+const (
+	tableIDWidth        = 36
+	tableAttributeWidth = 13
+	tableBodyWidth      = 80
+)
+
+var (
+	// csvColumnName matches the name in a name=<JMESPath> column. Anything else is taken to be only a JMESPath.
+	csvColumnName = regexp.MustCompile(`^[A-Za-z0-9_\- ]+=[^=]`)
+)
+
+// Formatter writes messages for WriterSink and FileSink. Implement it to plug in your own format.
+type Formatter interface {
+	// Header is written once before the first message of each output, like the first line of a CSV file. It may
+	// write nothing.
+	Header(w io.Writer) error
+
+	// Format writes a single message
+	Format(w io.Writer, msg MessageOutput) error
+
+	// Extension is the file extension FileSink uses, like .ndjson
+	Extension() string
+}
+
+// NewFormatter returns the Formatter for one of the formats. attributes are the system attributes, like
+// SentTimestamp or ApproximateReceiveCount, to include with each message. columns are only used by the CSV format.
+func NewFormatter(format string, attributes []string, columns []string) (Formatter, error) {
+	switch format {
+	case FormatNDJSON, "":
+		return &NDJSONFormatter{Attributes: attributes}, nil
+	case FormatJSON:
+		return &JSONFormatter{Attributes: attributes}, nil
+	case FormatCSV:
+		return NewCSVFormatter(columns, attributes)
+	case FormatRaw:
+		return &RawFormatter{Attributes: attributes}, nil
+	case FormatTable:
+		return &TableFormatter{Attributes: attributes}, nil
+	}
+
+	return nil, fmt.Errorf(
+		"unknown format '%v'. expected one of: %v, %v, %v, %v, %v",
+		format,
+		FormatNDJSON,
+		FormatJSON,
+		FormatCSV,
+		FormatRaw,
+		FormatTable,
+	)
+}
+
+// NDJSONFormatter writes each message as a MessageOutput on its own line. This is what Send reads back with
+// SendFormatDump.
+type NDJSONFormatter struct {
+	Attributes []string
+}
+
+// Header writes nothing
+func (n *NDJSONFormatter) Header(w io.Writer) error {
+	return nil
+}
+
+// Format writes the message as a line of JSON
+func (n *NDJSONFormatter) Format(w io.Writer, msg MessageOutput) error {
+	return json.NewEncoder(w).Encode(msg.withAttributes(n.Attributes))
+}
+
+// Extension is .ndjson
+func (n *NDJSONFormatter) Extension() string {
+	return ".ndjson"
+}
+
+// JSONFormatter writes each message as an indented MessageOutput. The output is a stream of JSON objects which
+// tools like jq read one at a time.
+type JSONFormatter struct {
+	Attributes []string
+}
+
+// Header writes nothing
+func (j *JSONFormatter) Header(w io.Writer) error {
+	return nil
+}
+
+// Format writes the message as indented JSON
+func (j *JSONFormatter) Format(w io.Writer, msg MessageOutput) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(msg.withAttributes(j.Attributes))
+}
+
+// Extension is .json
+func (j *JSONFormatter) Extension() string {
+	return ".json"
+}
+
+// RawFormatter writes only the body of each message on its own line. Attributes are written before the body as
+// tab separated name=value pairs.
+type RawFormatter struct {
+	Attributes []string
+}
+
+// Header writes nothing
+func (r *RawFormatter) Header(w io.Writer) error {
+	return nil
+}
+
+// Format writes the body
+func (r *RawFormatter) Format(w io.Writer, msg MessageOutput) error {
+	var line strings.Builder
+	for _, name := range r.Attributes {
+		line.WriteString(name)
+		line.WriteString("=")
+		line.WriteString(aws.StringValue(msg.Attributes[name]))
+		line.WriteString("\t")
+	}
+
+	line.WriteString(aws.StringValue(msg.Body))
+	line.WriteString("\n")
+
+	_, err := io.WriteString(w, line.String())
+	return err
+}
+
+// Extension is .txt
+func (r *RawFormatter) Extension() string {
+	return ".txt"
+}
+
+// CSVColumn is a column of a CSV file filled in by a JMESPath expression over the body
+type CSVColumn struct {
+	Name     string
+	JMESPath string
+
+	compiled *jmespath.JMESPath
+}
+
+// NewCSVFormatter returns an initialized CSVFormatter if the columns compile. Columns are either a JMESPath, which
+// is also used as the column name, or name=<JMESPath>. With no columns the whole body is written.
+func NewCSVFormatter(columns []string, attributes []string) (*CSVFormatter, error) {
+	c := &CSVFormatter{Attributes: attributes}
+
+	for _, column := range columns {
+		col := CSVColumn{Name: column, JMESPath: column}
+		if csvColumnName.MatchString(column) {
+			parts := strings.SplitN(column, "=", 2)
+			col.Name = strings.TrimSpace(parts[0])
+			col.JMESPath = strings.TrimSpace(parts[1])
+		}
+
+		compiled, err := jmespath.Compile(col.JMESPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile JMESPath for CSV column '%v' in NewCSVFormatter: %v", col.Name, err)
+		}
+
+		col.compiled = compiled
+		c.Columns = append(c.Columns, col)
+	}
+
+	return c, nil
+}
+
+// CSVFormatter writes the MessageId, the Attributes, and each of the Columns of every message as CSV. Columns that
+// evaluate to something other than a string are written as JSON and bodies that aren't JSON leave them empty.
+//
+// Use NewCSVFormatter to build one.
+type CSVFormatter struct {
+	Columns    []CSVColumn
+	Attributes []string
+}
+
+// Header writes the column names
+func (c *CSVFormatter) Header(w io.Writer) error {
+	header := append([]string{"MessageId"}, c.Attributes...)
+	if len(c.Columns) == 0 {
+		header = append(header, "Body")
+	}
+
+	for _, col := range c.Columns {
+		header = append(header, col.Name)
+	}
+
+	return writeCSV(w, header)
+}
+
+// Format writes a row for the message
+func (c *CSVFormatter) Format(w io.Writer, msg MessageOutput) error {
+	row := []string{aws.StringValue(msg.MessageId)}
+	for _, name := range c.Attributes {
+		row = append(row, aws.StringValue(msg.Attributes[name]))
+	}
+
+	if len(c.Columns) == 0 {
+		return writeCSV(w, append(row, aws.StringValue(msg.Body)))
+	}
+
+	var body interface{}
+	err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &body)
+	if err != nil {
+		body = nil
+	}
+
+	for _, col := range c.Columns {
+		if body == nil {
+			row = append(row, "")
+			continue
+		}
+
+		row = append(row, searchString(col.compiled, body))
+	}
+
+	return writeCSV(w, row)
+}
+
+// Extension is .csv
+func (c *CSVFormatter) Extension() string {
+	return ".csv"
+}
+
+// searchString runs the JMESPath against a parsed body. Strings are returned as they are and anything else as
+// JSON. It returns an empty string if the JMESPath finds nothing.
+func searchString(compiled *jmespath.JMESPath, body interface{}) string {
+	out, err := compiled.Search(body)
+	if err != nil || out == nil {
+		return ""
+	}
+
+	if s, ok := out.(string); ok {
+		return s
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+func writeCSV(w io.Writer, row []string) error {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write(row)
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// TableFormatter writes fixed width columns that are easy to scan in a terminal. Long values are cut short so use
+// another format to see whole bodies.
+type TableFormatter struct {
+	Attributes []string
+}
+
+// Header writes the column names
+func (t *TableFormatter) Header(w io.Writer) error {
+	cells := []string{pad("MessageId", tableIDWidth)}
+	for _, name := range t.Attributes {
+		cells = append(cells, pad(name, attributeWidth(name)))
+	}
+	cells = append(cells, "Body")
+
+	_, err := fmt.Fprintln(w, strings.Join(cells, "  "))
+	return err
+}
+
+// Format writes a row for the message
+func (t *TableFormatter) Format(w io.Writer, msg MessageOutput) error {
+	cells := []string{pad(aws.StringValue(msg.MessageId), tableIDWidth)}
+	for _, name := range t.Attributes {
+		cells = append(cells, pad(aws.StringValue(msg.Attributes[name]), attributeWidth(name)))
+	}
+
+	body := strings.Join(strings.Fields(aws.StringValue(msg.Body)), " ")
+	cells = append(cells, truncate(body, tableBodyWidth))
+
+	_, err := fmt.Fprintln(w, strings.Join(cells, "  "))
+	return err
+}
+
+// Extension is .txt
+func (t *TableFormatter) Extension() string {
+	return ".txt"
+}
+
+func attributeWidth(name string) int {
+	if len(name) > tableAttributeWidth {
+		return len(name)
+	}
+
+	return tableAttributeWidth
+}
+
+// pad cuts s to width and fills the rest with spaces
+func pad(s string, width int) string {
+	s = truncate(s, width)
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+// truncate cuts s to width runes ending in ... when it is too long
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+
+	runes := []rune(s)
+	return string(runes[:width-3]) + "..."
+}
+
+// withAttributes returns a copy of the MessageOutput with only the named system attributes. The MessageGroupId and
+// MessageDeduplicationId of a FIFO message are always kept so that Send can restore them. The ReceiptHandle is
+// dropped since it is useless once the message has been written out.
+func (m MessageOutput) withAttributes(names []string) MessageOutput {
+	all := m.Attributes
+	m.Attributes = nil
+	m.ReceiptHandle = nil
+
+	names = append([]string{messageGroupIDAttribute, messageDeduplicationIDAttribute}, names...)
+	for _, name := range names {
+		v, ok := all[name]
+		if !ok || v == nil {
+			continue
+		}
+
+		if m.Attributes == nil {
+			m.Attributes = make(map[string]*string, len(names))
+		}
+		m.Attributes[name] = v
+	}
+
+	return m
+}
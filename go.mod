@@ -6,6 +6,7 @@ require (
 	github.com/aws/aws-sdk-go v1.13.25
 	github.com/go-ini/ini v1.33.0 // indirect
 	github.com/jmespath/go-jmespath v0.0.0-20160202185014-0b12d6b521d8
+	github.com/klauspost/compress v1.11.13
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/stretchr/testify v1.4.0 // indirect
 	golang.org/x/net v0.0.0-20191109021931-daa7c04131f5 // indirect
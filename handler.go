@@ -8,6 +8,9 @@ import (
 
 // Handler represents any type that can process SQS messages. Messages returned by the handler will be removed from
 // the source queue if there is one. In other words the handler need not worry about the lifecyle of the SQS messages.
+//
+// Messages returned along with an error are still removed, so a handler that only got part of the way through a
+// batch should return the messages it finished. The rest stay in the source queue to be tried again.
 type Handler interface {
 	Handle(context.Context, []*sqs.Message) ([]*sqs.Message, error)
 }
@@ -0,0 +1,118 @@
+package sqsdr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// maxVisibilityExtension is the longest SQS lets a message stay hidden after it was received
+	maxVisibilityExtension = 12 * time.Hour
+)
+
+// Heartbeat keeps the batch a Poller is handling hidden from other consumers for as long as the Handler takes, so
+// a slow Handler or Sinker doesn't see its messages handled a second time. The zero value never changes visibility.
+type Heartbeat struct {
+	// HeartbeatInterval is how often the visibility timeout of the batch in flight is extended. Each extension
+	// hides the batch for two intervals from then. Zero turns the heartbeat off.
+	HeartbeatInterval time.Duration
+
+	// MaxHeartbeatExtension stops extending a batch once this long has passed since it was received. Zero extends
+	// until the Handler returns, up to the 12 hour limit of SQS.
+	MaxHeartbeatExtension time.Duration
+}
+
+// startHeartbeat extends the visibility timeout of msgs every HeartbeatInterval until the returned function is
+// called. The returned function waits for an extension in progress so that it never races with the delete.
+func (p *Poller) startHeartbeat(ctx context.Context, msgs []*sqs.Message) func() {
+	if p.HeartbeatInterval <= 0 {
+		return func() {}
+	}
+
+	received := time.Now()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(p.HeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				timeout, ok := p.heartbeatTimeout(now.Sub(received))
+				if !ok {
+					log.Println("heartbeat reached its maximum extension. the batch in flight may become visible before it's handled")
+					return
+				}
+
+				err := p.extendVisibility(ctx, msgs, timeout)
+				if err != nil {
+					// The next beat may still work and the Handler is none the wiser, so carry on
+					log.Println("could not extend the visibility timeout of the batch in flight:", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// heartbeatTimeout is the visibility timeout in seconds of the next extension. It returns false once the batch
+// can't be extended any further.
+func (p *Poller) heartbeatTimeout(elapsed time.Duration) (int64, bool) {
+	limit := maxVisibilityExtension
+	if p.MaxHeartbeatExtension > 0 && p.MaxHeartbeatExtension < limit {
+		limit = p.MaxHeartbeatExtension
+	}
+
+	timeout := int64(math.Ceil((2 * p.HeartbeatInterval).Seconds()))
+	remaining := int64((limit - elapsed) / time.Second)
+	if remaining < timeout {
+		timeout = remaining
+	}
+
+	return timeout, timeout > 0
+}
+
+func (p *Poller) extendVisibility(ctx context.Context, msgs []*sqs.Message, timeout int64) error {
+	entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, len(msgs))
+	for i, msg := range msgs {
+		entries[i] = &sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                msg.MessageId,
+			ReceiptHandle:     msg.ReceiptHandle,
+			VisibilityTimeout: aws.Int64(timeout),
+		}
+	}
+
+	resp, err := p.Client.ChangeMessageVisibilityBatchWithContext(
+		ctx,
+		&sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: aws.String(p.QueueURL),
+			Entries:  entries,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Failed) > 0 {
+		return compileFailedErrors(fmt.Sprintf("failed to extend the visibility of %v messages", len(resp.Failed)), resp.Failed)
+	}
+
+	log.Printf("extended the visibility timeout of %v messages by %vs\n", len(msgs), timeout)
+	return nil
+}
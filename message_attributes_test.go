@@ -0,0 +1,111 @@
+package sqsdr
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+// testMessageAttributes uses every data type SQS supports, including a custom type suffix
+func testMessageAttributes() map[string]*sqs.MessageAttributeValue {
+	return map[string]*sqs.MessageAttributeValue{
+		"trace-id":  {DataType: aws.String("String"), StringValue: aws.String("1-5759e988-bd862e3fe1be46a994272793")},
+		"attempt":   {DataType: aws.String("Number"), StringValue: aws.String("3")},
+		"price":     {DataType: aws.String("Number.USD"), StringValue: aws.String("12.50")},
+		"signature": {DataType: aws.String("Binary"), BinaryValue: []byte{0x00, 0xff, 0x10}},
+		"tenant":    {DataType: aws.String("String.Tenant"), StringValue: aws.String("acme")},
+	}
+}
+
+// sendWithAttributes sends each body with testMessageAttributes
+func sendWithAttributes(t *testing.T, s *sqsdrtest.SQS, queueURL string, bodies ...string) {
+	t.Helper()
+
+	for _, body := range bodies {
+		_, err := s.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:          aws.String(queueURL),
+			MessageBody:       aws.String(body),
+			MessageAttributes: testMessageAttributes(),
+		})
+		if err != nil {
+			t.Fatalf("SendMessage returned an error: %v", err)
+		}
+	}
+}
+
+// assertAttributes fails the test unless every message in the queue has testMessageAttributes
+func assertAttributes(t *testing.T, s *sqsdrtest.SQS, queueURL string) {
+	t.Helper()
+
+	want := testMessageAttributes()
+	for _, msg := range s.Messages(queueURL) {
+		if !reflect.DeepEqual(msg.MessageAttributes, want) {
+			t.Errorf("%v has the message attributes %v; want %v", aws.StringValue(msg.Body), msg.MessageAttributes, want)
+		}
+	}
+}
+
+func TestSimpleRedriveKeepsMessageAttributes(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendWithAttributes(t, s, src, "one", "two")
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest}
+	err := r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	assertBodies(t, s, dest, "one", "two")
+	assertAttributes(t, s, dest)
+}
+
+func TestFilteredRedriveKeepsMessageAttributes(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendWithAttributes(t, s, src, "move-1", "keep-1", "move-2")
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest, Regex: "move"}
+	err := r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	// Both the redriven messages and the ones that went through the fallthrough queue and back keep them
+	assertBodies(t, s, dest, "move-1", "move-2")
+	assertAttributes(t, s, dest)
+	assertBodies(t, s, src, "keep-1")
+	assertAttributes(t, s, src)
+}
+
+func TestDumpAndSendKeepMessageAttributes(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendWithAttributes(t, s, src, `{"id":1}`, "plain text")
+
+	var out bytes.Buffer
+	d := &Dump{SourceClient: s, SourceQueueURL: src, Out: &out}
+	err := d.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("Dump returned an error: %v", err)
+	}
+
+	assertAttributes(t, s, src)
+
+	send := &Send{DestClient: s, DestQueueURL: dest, In: &out, Format: SendFormatDump}
+	err = send.Send(context.Background())
+	if err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	assertBodies(t, s, dest, `{"id":1}`, "plain text")
+	assertAttributes(t, s, dest)
+}
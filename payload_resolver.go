@@ -0,0 +1,155 @@
+package sqsdr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// payloadS3PointerClass marks a body that the SQS Extended Client Library offloaded to S3
+	payloadS3PointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
+	// messageS3PointerClass is the marker written by versions of the Java SQS Extended Client before 2.0
+	messageS3PointerClass = "com.amazon.sqs.javamessaging.MessageS3Pointer"
+)
+
+// PayloadResolver fetches the real body of a message whose body was offloaded somewhere else, like S3
+type PayloadResolver interface {
+	// Resolve returns a copy of the message with its real body. Messages that weren't offloaded are returned as
+	// they are.
+	Resolve(context.Context, *sqs.Message) (*sqs.Message, error)
+}
+
+// S3Pointer is where the SQS Extended Client put the body of a message
+type S3Pointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+// ParseS3Pointer reads a body like ["software.amazon.payloadoffloading.PayloadS3Pointer", {"s3BucketName": "...",
+// "s3Key": "..."}]. It returns false if the body isn't a pointer.
+func ParseS3Pointer(body string) (S3Pointer, bool) {
+	// Skip parsing every JSON body on the way through
+	trimmed := strings.TrimSpace(body)
+	if !strings.HasPrefix(trimmed, "[") || !strings.Contains(trimmed, "S3Pointer") {
+		return S3Pointer{}, false
+	}
+
+	var parts []json.RawMessage
+	err := json.Unmarshal([]byte(trimmed), &parts)
+	if err != nil || len(parts) != 2 {
+		return S3Pointer{}, false
+	}
+
+	var class string
+	err = json.Unmarshal(parts[0], &class)
+	if err != nil || (class != payloadS3PointerClass && class != messageS3PointerClass) {
+		return S3Pointer{}, false
+	}
+
+	var pointer S3Pointer
+	err = json.Unmarshal(parts[1], &pointer)
+	if err != nil || pointer.Bucket == "" || pointer.Key == "" {
+		return S3Pointer{}, false
+	}
+
+	return pointer, true
+}
+
+// S3PayloadResolver resolves the S3 pointers written by the SQS Extended Client by downloading the object. Use
+// CreateS3ClientWithConfig to point it at an S3 compatible service such as MinIO.
+type S3PayloadResolver struct {
+	Client s3iface.S3API
+}
+
+// Resolve downloads the body of the message if it is an S3 pointer
+func (s *S3PayloadResolver) Resolve(ctx context.Context, msg *sqs.Message) (*sqs.Message, error) {
+	pointer, ok := ParseS3Pointer(aws.StringValue(msg.Body))
+	if !ok {
+		return msg, nil
+	}
+
+	resp, err := s.Client.GetObjectWithContext(
+		ctx,
+		&s3.GetObjectInput{
+			Bucket: aws.String(pointer.Bucket),
+			Key:    aws.String(pointer.Key),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the payload of message %v from s3://%v/%v: %v", aws.StringValue(msg.MessageId), pointer.Bucket, pointer.Key, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the payload of message %v from s3://%v/%v: %v", aws.StringValue(msg.MessageId), pointer.Bucket, pointer.Key, err)
+	}
+
+	resolved := *msg
+	resolved.Body = aws.String(string(body))
+	return &resolved, nil
+}
+
+// resolveMessages returns copies of msgs with their real bodies along with the messages that could not be resolved.
+// Failures are logged rather than returned so that one missing object doesn't hold up the rest of the batch. msgs
+// are returned as they are when the resolver is nil.
+func resolveMessages(ctx context.Context, resolver PayloadResolver, msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	if resolver == nil {
+		return msgs, nil
+	}
+
+	resolved := make([]*sqs.Message, 0, len(msgs))
+	var failed []*sqs.Message
+	for _, msg := range msgs {
+		r, err := resolver.Resolve(ctx, msg)
+		if err != nil {
+			log.Println("could not resolve payload:", err)
+			failed = append(failed, msg)
+			continue
+		}
+
+		resolved = append(resolved, r)
+	}
+
+	return resolved, failed
+}
+
+// originalMessages maps resolved messages back to the received messages they were copied from so that sinks get
+// the pointers instead of the payloads
+func originalMessages(resolved []*sqs.Message, msgs []*sqs.Message) []*sqs.Message {
+	byID := make(map[string]*sqs.Message, len(msgs))
+	for _, msg := range msgs {
+		byID[aws.StringValue(msg.MessageId)] = msg
+	}
+
+	originals := make([]*sqs.Message, 0, len(resolved))
+	for _, msg := range resolved {
+		if original, ok := byID[aws.StringValue(msg.MessageId)]; ok {
+			originals = append(originals, original)
+		}
+	}
+
+	return originals
+}
+
+// resolvingSink resolves the bodies of the messages before sinking them into passthrough. Messages that could not be
+// resolved are sunk as they are.
+type resolvingSink struct {
+	resolver    PayloadResolver
+	passthrough Sinker
+}
+
+func (r *resolvingSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
+	resolved, failed := resolveMessages(ctx, r.resolver, msgs)
+	return r.passthrough.Sink(ctx, append(resolved, failed...))
+}
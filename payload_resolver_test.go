@@ -0,0 +1,251 @@
+package sqsdr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestParseS3Pointer(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want S3Pointer
+		ok   bool
+	}{
+		{
+			name: "payload offloading pointer",
+			body: `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket","s3Key":"key"}]`,
+			want: S3Pointer{Bucket: "bucket", Key: "key"},
+			ok:   true,
+		},
+		{
+			name: "java messaging pointer",
+			body: `["com.amazon.sqs.javamessaging.MessageS3Pointer",{"s3BucketName":"bucket","s3Key":"key"}]`,
+			want: S3Pointer{Bucket: "bucket", Key: "key"},
+			ok:   true,
+		},
+		{
+			name: "surrounding whitespace",
+			body: "  [\"software.amazon.payloadoffloading.PayloadS3Pointer\", {\"s3BucketName\": \"bucket\", \"s3Key\": \"key\"}]\n",
+			want: S3Pointer{Bucket: "bucket", Key: "key"},
+			ok:   true,
+		},
+		{name: "plain text", body: "hello"},
+		{name: "json object", body: `{"s3BucketName":"bucket","s3Key":"key"}`},
+		{name: "empty", body: ""},
+		{name: "unknown class", body: `["com.example.S3Pointer",{"s3BucketName":"bucket","s3Key":"key"}]`},
+		{name: "missing key", body: `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket"}]`},
+		{name: "missing bucket", body: `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3Key":"key"}]`},
+		{name: "too many parts", body: `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"bucket","s3Key":"key"},1]`},
+		{name: "invalid json", body: `["software.amazon.payloadoffloading.PayloadS3Pointer",`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseS3Pointer(tt.body)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("ParseS3Pointer(%q) = %+v, %v; want %+v, %v", tt.body, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// stubS3 serves GetObject from a map of bucket/key to body
+type stubS3 struct {
+	s3iface.S3API
+
+	objects map[string]string
+	calls   int
+}
+
+func (s *stubS3) GetObjectWithContext(ctx aws.Context, req *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	s.calls++
+
+	body, ok := s.objects[aws.StringValue(req.Bucket)+"/"+aws.StringValue(req.Key)]
+	if !ok {
+		return nil, errors.New("NoSuchKey: The specified key does not exist.")
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewBufferString(body))}, nil
+}
+
+func pointerBody(bucket, key string) string {
+	return `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"` + bucket + `","s3Key":"` + key + `"}]`
+}
+
+func TestS3PayloadResolver(t *testing.T) {
+	client := &stubS3{objects: map[string]string{"bucket/key": `{"real":"payload"}`}}
+	resolver := &S3PayloadResolver{Client: client}
+
+	msg := &sqs.Message{MessageId: aws.String("1"), Body: aws.String(pointerBody("bucket", "key"))}
+	resolved, err := resolver.Resolve(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Resolve returned an error: %v", err)
+	}
+
+	if got := aws.StringValue(resolved.Body); got != `{"real":"payload"}` {
+		t.Errorf("resolved body = %q; want the payload", got)
+	}
+
+	if aws.StringValue(msg.Body) != pointerBody("bucket", "key") {
+		t.Errorf("Resolve changed the body of the original message to %q", aws.StringValue(msg.Body))
+	}
+
+	if aws.StringValue(resolved.MessageId) != "1" {
+		t.Errorf("resolved MessageId = %q; want 1", aws.StringValue(resolved.MessageId))
+	}
+}
+
+func TestS3PayloadResolverPlainBody(t *testing.T) {
+	client := &stubS3{}
+	resolver := &S3PayloadResolver{Client: client}
+
+	msg := &sqs.Message{MessageId: aws.String("1"), Body: aws.String("plain")}
+	resolved, err := resolver.Resolve(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Resolve returned an error: %v", err)
+	}
+
+	if resolved != msg {
+		t.Errorf("Resolve returned a copy of a message that isn't a pointer")
+	}
+
+	if client.calls != 0 {
+		t.Errorf("Resolve called S3 %v times for a plain body", client.calls)
+	}
+}
+
+func TestS3PayloadResolverMissingObject(t *testing.T) {
+	resolver := &S3PayloadResolver{Client: &stubS3{}}
+
+	msg := &sqs.Message{MessageId: aws.String("1"), Body: aws.String(pointerBody("bucket", "missing"))}
+	_, err := resolver.Resolve(context.Background(), msg)
+	if err == nil {
+		t.Fatal("Resolve of a missing object did not return an error")
+	}
+}
+
+func TestResolveMessagesKeepsGoingAfterFailure(t *testing.T) {
+	resolver := &S3PayloadResolver{Client: &stubS3{objects: map[string]string{"bucket/key": "payload"}}}
+
+	msgs := []*sqs.Message{
+		{MessageId: aws.String("1"), Body: aws.String(pointerBody("bucket", "missing"))},
+		{MessageId: aws.String("2"), Body: aws.String(pointerBody("bucket", "key"))},
+		{MessageId: aws.String("3"), Body: aws.String("plain")},
+	}
+
+	resolved, failed := resolveMessages(context.Background(), resolver, msgs)
+	if len(failed) != 1 || failed[0] != msgs[0] {
+		t.Fatalf("failed = %v; want only the message with the missing object", failed)
+	}
+
+	if len(resolved) != 2 || aws.StringValue(resolved[0].Body) != "payload" || aws.StringValue(resolved[1].Body) != "plain" {
+		t.Fatalf("resolved = %v; want the other two messages with their real bodies", resolved)
+	}
+}
+
+// recordingSink remembers the messages it was asked to sink
+type recordingSink struct {
+	msgs []*sqs.Message
+}
+
+func (r *recordingSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
+	r.msgs = append(r.msgs, msgs...)
+	return nil
+}
+
+func TestPipelineSendsUnresolvedMessagesRight(t *testing.T) {
+	resolver := &S3PayloadResolver{Client: &stubS3{objects: map[string]string{"bucket/key": "payload"}}}
+	left := &recordingSink{}
+	right := &recordingSink{}
+
+	p := &Pipeline{
+		Chooser:   &PassthroughChooser{},
+		LeftSink:  left,
+		RightSink: right,
+		Resolver:  resolver,
+	}
+
+	msgs := []*sqs.Message{
+		{MessageId: aws.String("1"), Body: aws.String(pointerBody("bucket", "missing"))},
+		{MessageId: aws.String("2"), Body: aws.String(pointerBody("bucket", "key"))},
+	}
+
+	handled, err := p.Handle(context.Background(), msgs)
+	if err != nil {
+		t.Fatalf("Handle returned an error: %v", err)
+	}
+
+	if len(handled) != 2 {
+		t.Errorf("Handle returned %v messages; want both", len(handled))
+	}
+
+	if len(left.msgs) != 1 || left.msgs[0] != msgs[1] {
+		t.Errorf("left sink got %v; want the resolvable message as it was received", left.msgs)
+	}
+
+	if len(right.msgs) != 1 || right.msgs[0] != msgs[0] {
+		t.Errorf("right sink got %v; want the unresolvable message", right.msgs)
+	}
+}
+
+func TestChooserSinkPassesUnresolvedMessagesThrough(t *testing.T) {
+	resolver := &S3PayloadResolver{Client: &stubS3{objects: map[string]string{"bucket/key": "payload"}}}
+	left := &recordingSink{}
+	pass := &recordingSink{}
+
+	c := &chooserSink{
+		Chooser:     &PassthroughChooser{},
+		Left:        left,
+		Passthrough: pass,
+		Resolver:    resolver,
+	}
+
+	msgs := []*sqs.Message{
+		{MessageId: aws.String("1"), Body: aws.String(pointerBody("bucket", "missing"))},
+		{MessageId: aws.String("2"), Body: aws.String(pointerBody("bucket", "key"))},
+	}
+
+	err := c.Sink(context.Background(), msgs)
+	if err != nil {
+		t.Fatalf("Sink returned an error: %v", err)
+	}
+
+	if len(left.msgs) != 1 || aws.StringValue(left.msgs[0].Body) != "payload" {
+		t.Errorf("left got %v; want only the resolved message", left.msgs)
+	}
+
+	if len(pass.msgs) != 2 {
+		t.Errorf("passthrough got %v messages; want both", len(pass.msgs))
+	}
+}
+
+func TestTransformSkipsS3Pointers(t *testing.T) {
+	transformer, err := ParseTransformExpression(`merge:{"a": 1}`)
+	if err != nil {
+		t.Fatalf("ParseTransformExpression returned an error: %v", err)
+	}
+
+	msgs := []*sqs.Message{
+		{MessageId: aws.String("1"), Body: aws.String(pointerBody("bucket", "key"))},
+		{MessageId: aws.String("2"), Body: aws.String(`{"b": 2}`)},
+	}
+
+	transformed, failed := transform(transformer, msgs)
+	if len(failed) != 1 || failed[0] != msgs[0] {
+		t.Errorf("failed = %v; want the pointer", failed)
+	}
+
+	if len(transformed) != 1 || aws.StringValue(transformed[0].MessageId) != "2" {
+		t.Errorf("transformed = %v; want only the plain message", transformed)
+	}
+}
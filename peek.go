@@ -0,0 +1,196 @@
+package sqsdr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	// defaultPeekVisibilityTimeout hides peeked messages for 15 minutes which should be plenty of time to
+	// read an entire queue
+	defaultPeekVisibilityTimeout int64 = 900
+)
+
+// Peek writes every message in a queue to Out without deleting or re-sending them. Messages are hidden from
+// other consumers with a long visibility timeout while the queue is read, so unlike Dump there is no
+// temporary queue and the messages keep their age. Receiving a message still increments its
+// ApproximateReceiveCount.
+type Peek struct {
+	SourceClient   sqsiface.SQSAPI
+	SourceQueueURL string
+	Out            io.Writer
+
+	// Formatter optionally changes how messages are written to Out. Defaults to an NDJSONFormatter.
+	Formatter Formatter
+
+	// VisibilityTimeout is the number of seconds messages are hidden for while the queue is read. It needs to
+	// be longer than it takes to read the whole queue or messages will be seen more than once.
+	VisibilityTimeout int64
+
+	// ResetVisibility makes the messages visible again as soon as the queue has been read
+	ResetVisibility bool
+
+	// Concurrency is the number of workers polling the source queue
+	Concurrency int
+
+	// Observer is optionally told how many messages were received
+	Observer Observer
+
+	// Resolver optionally fetches offloaded bodies so that Out gets the real ones
+	Resolver PayloadResolver
+
+	StopConditions
+}
+
+// Peek is the entry point into the peek strategy. Cancelling ctx stops after the batches in flight. The
+// visibility is still reset when ResetVisibility is set.
+func (p *Peek) Peek(ctx context.Context) error {
+	sink := &WriterSink{
+		Writer:      p.Out,
+		Passthrough: NoOpSink{},
+		Formatter:   p.Formatter,
+	}
+
+	return p.peek(ctx, sink)
+}
+
+// peek passes every message in the queue to the sink exactly once without deleting any of them
+func (p *Peek) peek(ctx context.Context, sink Sinker) error {
+	visibilityTimeout := p.VisibilityTimeout
+	if visibilityTimeout <= 0 {
+		visibilityTimeout = defaultPeekVisibilityTimeout
+	}
+
+	if p.Resolver != nil {
+		sink = &resolvingSink{resolver: p.Resolver, passthrough: sink}
+	}
+
+	handler := &peekHandler{
+		sink: sink,
+		seen: make(map[string]string),
+	}
+
+	poller := NewPoller(p.SourceQueueURL, p.SourceClient, handler)
+	poller.Concurrency = p.Concurrency
+	poller.VisibilityTimeout = visibilityTimeout
+	poller.StopConditions = p.StopConditions
+	poller.Observer = p.Observer
+
+	err := poller.Process(ctx)
+
+	if p.ResetVisibility {
+		log.Println("making peeked messages visible again")
+		resetErr := resetVisibility(uncancelable(ctx), p.SourceClient, p.SourceQueueURL, handler.receiptHandles())
+		if resetErr != nil && err == nil {
+			err = resetErr
+		}
+	}
+
+	return err
+}
+
+// peekHandler writes messages to a sink and returns none of them so the Poller never deletes anything. It
+// remembers the receipt handle of every message it has seen so that the visibility can be reset at the end.
+type peekHandler struct {
+	sink Sinker
+
+	mu   sync.Mutex
+	seen map[string]string
+}
+
+// Handle writes messages that haven't been seen before to the sink
+func (p *peekHandler) Handle(ctx context.Context, msgs []*sqs.Message) ([]*sqs.Message, error) {
+	unseen := make([]*sqs.Message, 0, len(msgs))
+
+	p.mu.Lock()
+	for _, msg := range msgs {
+		_, ok := p.seen[*msg.MessageId]
+
+		// Always keep the latest receipt handle. Older ones can't change the visibility of the message.
+		p.seen[*msg.MessageId] = *msg.ReceiptHandle
+		if !ok {
+			unseen = append(unseen, msg)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(unseen) > 0 {
+		err := p.sink.Sink(ctx, unseen)
+		if err != nil {
+			return emptyMessages, err
+		}
+	}
+
+	return emptyMessages, nil
+}
+
+func (p *peekHandler) receiptHandles() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	handles := make(map[string]string, len(p.seen))
+	for id, handle := range p.seen {
+		handles[id] = handle
+	}
+
+	return handles
+}
+
+// resetVisibility sets the visibility timeout of the messages to 0 in batches of 10. receiptHandles is a
+// map of MessageId to ReceiptHandle.
+func resetVisibility(ctx context.Context, client sqsiface.SQSAPI, queueURL string, receiptHandles map[string]string) error {
+	entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0, maxBatchEntries)
+	failed := make([]*sqs.BatchResultErrorEntry, 0)
+
+	flush := func() error {
+		if len(entries) == 0 {
+			return nil
+		}
+
+		resp, err := client.ChangeMessageVisibilityBatchWithContext(
+			ctx,
+			&sqs.ChangeMessageVisibilityBatchInput{
+				QueueUrl: aws.String(queueURL),
+				Entries:  entries,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("could not reset message visibility: %v", err)
+		}
+
+		failed = append(failed, resp.Failed...)
+		entries = make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0, maxBatchEntries)
+		return nil
+	}
+
+	for id, handle := range receiptHandles {
+		entries = append(entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(id),
+			ReceiptHandle:     aws.String(handle),
+			VisibilityTimeout: aws.Int64(0),
+		})
+
+		if len(entries) == maxBatchEntries {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return compileFailedErrors("failed to reset the visibility of some messages", failed)
+	}
+
+	return nil
+}
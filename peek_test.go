@@ -0,0 +1,74 @@
+package sqsdr
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+func TestPeek(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, src, "one", "two", "three")
+
+	var out bytes.Buffer
+	p := &Peek{SourceClient: s, SourceQueueURL: src, Out: &out, ResetVisibility: true}
+	err := p.Peek(context.Background())
+	if err != nil {
+		t.Fatalf("Peek returned an error: %v", err)
+	}
+
+	dumped := readDump(t, &out)
+	if got := dumpedBodies(dumped); len(got) != 3 {
+		t.Errorf("peeked %v; want every message", got)
+	}
+
+	// Nothing is deleted or re-sent and the messages are visible again right away
+	resp, err := s.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: aws.String(src), MaxNumberOfMessages: aws.Int64(10)})
+	if err != nil {
+		t.Fatalf("ReceiveMessage returned an error: %v", err)
+	}
+
+	if len(resp.Messages) != 3 {
+		t.Errorf("received %v messages after Peek; want 3", len(resp.Messages))
+	}
+
+	ids := make(map[string]bool)
+	for _, msg := range resp.Messages {
+		ids[aws.StringValue(msg.MessageId)] = true
+	}
+
+	for _, msg := range dumped {
+		if !ids[aws.StringValue(msg.MessageId)] {
+			t.Errorf("peeked message %v is not the one in the queue", aws.StringValue(msg.MessageId))
+		}
+	}
+}
+
+func TestPeekWithoutResetVisibility(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, src, "one")
+
+	var out bytes.Buffer
+	p := &Peek{SourceClient: s, SourceQueueURL: src, Out: &out}
+	err := p.Peek(context.Background())
+	if err != nil {
+		t.Fatalf("Peek returned an error: %v", err)
+	}
+
+	resp, err := s.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: aws.String(src)})
+	if err != nil {
+		t.Fatalf("ReceiveMessage returned an error: %v", err)
+	}
+
+	if len(resp.Messages) != 0 {
+		t.Errorf("received %v messages while they were hidden by Peek", len(resp.Messages))
+	}
+
+	assertBodies(t, s, src, "one")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
@@ -13,30 +14,100 @@ type Pipeline struct {
 	Chooser   Chooser
 	LeftSink  Sinker
 	RightSink Sinker
+
+	// Transformer optionally rewrites the messages headed for the LeftSink. Messages that fail to transform
+	// go to the RightSink instead.
+	Transformer Transformer
+
+	// Observer is optionally told how many messages were sent to each sink
+	Observer Observer
+
+	// Resolver optionally fetches offloaded bodies for the Chooser. The Transformer and the sinks still get the
+	// messages as they were received so that redriven messages keep their pointers. Messages that can't be
+	// resolved go to the RightSink.
+	Resolver PayloadResolver
 }
 
-// Handle is the entry point into the pipeline
+// Handle is the entry point into the pipeline. It returns the messages that were sunk, even when one of the sinks
+// failed, so that only those are deleted from the source queue.
 func (p *Pipeline) Handle(ctx context.Context, msgs []*sqs.Message) ([]*sqs.Message, error) {
-	leftMsgs, rightMsgs := p.Chooser.Choose(msgs)
+	resolved, unresolved := resolveMessages(ctx, p.Resolver, msgs)
 
-	var leftError error
-	if len(leftMsgs) > 0 {
-		leftError = p.LeftSink.Sink(ctx, leftMsgs)
+	leftMsgs, rightMsgs := p.Chooser.Choose(resolved)
+	if p.Resolver != nil {
+		leftMsgs = originalMessages(leftMsgs, msgs)
+		rightMsgs = append(originalMessages(rightMsgs, msgs), unresolved...)
 	}
 
-	var rightError error
-	if len(rightMsgs) > 0 {
-		rightError = p.RightSink.Sink(ctx, rightMsgs)
+	if p.Transformer != nil {
+		var failed []*sqs.Message
+		leftMsgs, failed = transform(p.Transformer, leftMsgs)
+		rightMsgs = append(rightMsgs, failed...)
 	}
 
+	if p.Observer != nil {
+		p.Observer.Chosen(len(leftMsgs), len(rightMsgs))
+	}
+
+	leftFailed, leftError := sinkMessages(ctx, p.LeftSink, leftMsgs)
+	rightFailed, rightError := sinkMessages(ctx, p.RightSink, rightMsgs)
+
 	var err error
 	if rightError != nil && leftError != nil {
 		err = fmt.Errorf("rightSink error: %v\nleftSink error: %v", rightError, leftError)
+
+		// The Poller keeps going after a PartialSinkError so keep the error partial when both sinks were
+		_, rightPartial := rightError.(*PartialSinkError)
+		_, leftPartial := leftError.(*PartialSinkError)
+		if rightPartial && leftPartial {
+			err = &PartialSinkError{Failed: append(leftFailed, rightFailed...), Err: err}
+		}
 	} else if rightError != nil {
 		err = rightError
 	} else if leftError != nil {
 		err = leftError
 	}
 
+	return withoutMessages(msgs, append(leftFailed, rightFailed...)), err
+}
+
+// sinkMessages sinks msgs into the sinker and returns the ones that weren't sunk. That's all of them unless the
+// sinker returned a PartialSinkError.
+func sinkMessages(ctx context.Context, sinker Sinker, msgs []*sqs.Message) ([]*sqs.Message, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	err := sinker.Sink(ctx, msgs)
+	if err == nil {
+		return nil, nil
+	}
+
+	if partial, ok := err.(*PartialSinkError); ok {
+		return partial.Failed, err
+	}
+
 	return msgs, err
 }
+
+// withoutMessages returns the messages in msgs that aren't in failed. Messages are matched by MessageId since
+// a Transformer hands the sinks copies of the received messages.
+func withoutMessages(msgs []*sqs.Message, failed []*sqs.Message) []*sqs.Message {
+	if len(failed) == 0 {
+		return msgs
+	}
+
+	failedIDs := make(map[string]bool, len(failed))
+	for _, msg := range failed {
+		failedIDs[aws.StringValue(msg.MessageId)] = true
+	}
+
+	succeeded := make([]*sqs.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if !failedIDs[aws.StringValue(msg.MessageId)] {
+			succeeded = append(succeeded, msg)
+		}
+	}
+
+	return succeeded
+}
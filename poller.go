@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -17,20 +21,44 @@ const (
 	maxNumberofMessages int64 = 10
 )
 
+var (
+	// allMessageAttributes asks SQS to return every message attribute on a received message
+	allMessageAttributes = []string{"All"}
+)
+
 type sqsClient interface {
 	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
 	DeleteMessageBatchWithContext(aws.Context, *sqs.DeleteMessageBatchInput, ...request.Option) (*sqs.DeleteMessageBatchOutput, error)
+	GetQueueAttributesWithContext(aws.Context, *sqs.GetQueueAttributesInput, ...request.Option) (*sqs.GetQueueAttributesOutput, error)
+	ChangeMessageVisibilityBatchWithContext(aws.Context, *sqs.ChangeMessageVisibilityBatchInput, ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+}
+
+// StopConditions stop a Poller before the queue is empty. This is useful for queues that producers are still
+// writing to, where waiting for MaxEmptyReceives could take forever. The zero value never stops early.
+type StopConditions struct {
+	// MaxMessages stops the Poller once this many messages have been received
+	MaxMessages int64
+
+	// Timeout stops the Poller once it has been running this long. Batches that are in flight are finished.
+	Timeout time.Duration
+
+	// StopAfterSnapshot reads ApproximateNumberOfMessages when Process starts and stops once that many messages have
+	// been received. Combined with MaxMessages the smaller of the two wins.
+	StopAfterSnapshot bool
 }
 
-// NewPoller returns a Poller that defaults to long polling and receiving at most 10 messages at a time
+// NewPoller returns a Poller that defaults to long polling, receiving at most 10 messages at a time, and
+// receiving all of the message attributes on each message
 func NewPoller(queueURL string, client sqsClient, handler Handler) *Poller {
 	return &Poller{
-		QueueURL:            queueURL,
-		Client:              client,
-		Handler:             handler,
-		MaxEmptyReceives:    maxEmptyReceives,
-		WaitTimeSeconds:     waitTimeSeconds,
-		MaxNumberOfMessages: maxNumberofMessages,
+		QueueURL:              queueURL,
+		Client:                client,
+		Handler:               handler,
+		MaxEmptyReceives:      maxEmptyReceives,
+		Concurrency:           1,
+		WaitTimeSeconds:       waitTimeSeconds,
+		MaxNumberOfMessages:   maxNumberofMessages,
+		MessageAttributeNames: allMessageAttributes,
 	}
 }
 
@@ -42,61 +70,349 @@ type Poller struct {
 	Client           sqsClient
 	MaxEmptyReceives int
 
+	// Concurrency is the number of workers that receive, handle, and delete messages in parallel.
+	// All workers share the same empty receive count. Values less than 1 are treated as 1.
+	Concurrency int
+
 	// SQS ReceiveMessage API pass through
 	WaitTimeSeconds     int64
 	MaxNumberOfMessages int64
+
+	// VisibilityTimeout in seconds is passed to ReceiveMessage when greater than zero. Otherwise the queue's
+	// visibility timeout is used.
+	VisibilityTimeout int64
+
+	// MessageAttributeNames are the message attributes to receive with each message. Sinks can only forward the
+	// attributes that were received so leave this as "All" unless you mean to drop attributes.
+	MessageAttributeNames []string
+
+	// Observer is optionally told how many messages were received, deleted, and failed
+	Observer Observer
+
+	// RetryPolicy optionally changes how messages that failed to delete are retried. Defaults to
+	// DefaultRetryPolicy.
+	RetryPolicy *RetryPolicy
+
+	// RateLimiter optionally limits how fast messages are received. Workers wait on it before each receive so that
+	// cancelling ctx stops the wait instead of holding on to a batch that was already received.
+	RateLimiter *RateLimiter
+
+	StopConditions
+	Heartbeat
 }
 
-// Process is the entry point for the Poller. It is a blocking function. If you desire more concurrency call Process() in a separate
-// go routine as many times as needed.
+// Process is the entry point for the Poller. It is a blocking function that runs Concurrency workers and returns
+// once the workers have seen MaxEmptyReceives empty responses between them or one of the StopConditions has been
+// met. The first error returned by a worker cancels the rest and is returned to the caller.
+//
+// A Handler that returns a PartialSinkError doesn't stop the run. The messages that failed are left in the queue,
+// the workers keep polling, and an error counting every failed message is returned at the end.
+//
+// Cancelling ctx stops the workers from receiving more messages. Batches that have already been received are
+// still handled and deleted, and then the error of ctx is returned.
 func (p *Poller) Process(ctx context.Context) error {
-	numEmptyReceives := 0
+	concurrency := p.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	run, err := p.newPollerRun(ctx)
+	if err != nil {
+		return err
+	}
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := p.work(workCtx, run)
+			if err != nil {
+				// Only the first error is interesting. Every worker after it is failing because we cancelled it.
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return run.sinkError()
+}
+
+// work runs the poll, handle, delete loop for a single worker until the shared empty receive count reaches
+// MaxEmptyReceives, a stop condition is met, ctx is cancelled, or an error occurs. Workers finish the batch they're
+// working on before checking so that messages are never sunk without being deleted.
+func (p *Poller) work(ctx context.Context, run *pollerRun) error {
 	for {
-		numProcessed, err := p.ProcessOnce(ctx)
-		if err != nil {
-			return err
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if atomic.LoadInt64(&run.numEmptyReceives) >= int64(p.MaxEmptyReceives) {
+			return nil
 		}
 
-		if numProcessed == 0 {
-			numEmptyReceives++
-			log.Printf("received empty response %v of %v", numEmptyReceives, p.MaxEmptyReceives)
+		if !run.deadline.IsZero() && time.Now().After(run.deadline) {
+			log.Println("timeout reached")
+			return nil
 		}
 
-		if numEmptyReceives >= p.MaxEmptyReceives {
+		maxMessages := run.reserve(p.MaxNumberOfMessages)
+		if maxMessages == 0 {
+			log.Println("message limit reached")
 			return nil
 		}
+
+		if p.RateLimiter != nil {
+			err := p.RateLimiter.Wait(ctx, int(maxMessages))
+			if err != nil {
+				run.release(maxMessages)
+				return nil
+			}
+		}
+
+		numReceived, err := p.processOnce(ctx, maxMessages)
+		run.release(maxMessages - int64(numReceived))
+		if p.RateLimiter != nil {
+			p.RateLimiter.Return(int(maxMessages) - numReceived)
+		}
+		if partial, ok := err.(*PartialSinkError); ok {
+			log.Printf("leaving %v messages that could not be sunk in the queue: %v\n", len(partial.Failed), partial.Err)
+			run.sinkFailed(partial)
+		} else if err != nil {
+			return err
+		}
+
+		if numReceived == 0 {
+			n := atomic.AddInt64(&run.numEmptyReceives, 1)
+			log.Printf("received empty response %v of %v", n, p.MaxEmptyReceives)
+		}
 	}
 }
 
-// ProcessOnce polls, handles, and deletes successfully processed messages from the queue one time.
+// ProcessOnce polls, handles, and deletes successfully processed messages from the queue one time. It returns the
+// number of messages received which may be more than were deleted if the Handler held on to some of them.
 // This could be handy if you're running Poller in an environment with a limited runtime like AWS Lambda.
 func (p *Poller) ProcessOnce(ctx context.Context) (int, error) {
-	numProcessed := 0
-	msgs, err := p.receiveMessages(ctx)
+	return p.processOnce(ctx, p.MaxNumberOfMessages)
+}
+
+func (p *Poller) processOnce(ctx context.Context, maxMessages int64) (int, error) {
+	msgs, err := p.receiveMessages(ctx, maxMessages)
 	if err != nil {
-		return numProcessed, err
+		// Nothing was received if we were told to stop in the middle of a long poll
+		if ctx.Err() != nil {
+			return 0, nil
+		}
+
+		return 0, err
 	}
 
-	if len(msgs) == 0 {
+	numReceived := len(msgs)
+	if numReceived == 0 {
 		return 0, nil
 	}
 
-	processed, err := p.Handler.Handle(ctx, msgs)
-	numProcessed = len(processed)
+	if p.Observer != nil {
+		p.Observer.Received(numReceived)
+	}
+
+	// Once the batch has been received it is handled and deleted even if ctx is cancelled. Stopping half way could
+	// send messages without deleting them.
+	ctx = uncancelable(ctx)
+
+	stopHeartbeat := p.startHeartbeat(ctx, msgs)
+	processed, handleErr := p.Handler.Handle(ctx, msgs)
+	stopHeartbeat()
+	if handleErr != nil && p.Observer != nil {
+		p.Observer.Failed(numReceived - len(processed))
+	}
+
+	// The messages the Handler finished are deleted even if it failed part way so that they aren't handled twice
+	if len(processed) > 0 {
+		err = p.deleteMessages(ctx, processed)
+		if p.Observer != nil {
+			if err != nil {
+				p.Observer.Failed(len(processed))
+			} else {
+				p.Observer.Deleted(len(processed))
+			}
+		}
+	}
+
+	// A PartialSinkError lets the run carry on but a failed delete would have the messages sunk again
+	if handleErr != nil && err != nil {
+		return numReceived, fmt.Errorf("%v\nthen failed to delete the messages that were sunk: %v", handleErr, err)
+	} else if err != nil {
+		return numReceived, err
+	}
+
+	return numReceived, handleErr
+}
+
+// newPollerRun works out the message budget and deadline for a call to Process from the StopConditions
+func (p *Poller) newPollerRun(ctx context.Context) (*pollerRun, error) {
+	run := &pollerRun{remaining: p.MaxMessages}
+
+	if p.Observer != nil {
+		n, err := p.approximateNumberOfMessages(ctx)
+		if err != nil {
+			// The Observer only uses this for an ETA so it isn't worth failing over
+			log.Println("could not read the queue size for progress:", err)
+			n = -1
+		}
+
+		p.Observer.Started(n)
+	}
+
+	if p.StopAfterSnapshot {
+		n, err := p.approximateNumberOfMessages(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("stopping after the %v messages in the queue at start\n", n)
+		if run.remaining <= 0 || n < run.remaining {
+			run.remaining = n
+		}
+
+		run.limited = true
+	}
+
+	if p.MaxMessages > 0 {
+		run.limited = true
+	}
+
+	if p.Timeout > 0 {
+		run.deadline = time.Now().Add(p.Timeout)
+	}
+
+	return run, nil
+}
+
+func (p *Poller) approximateNumberOfMessages(ctx context.Context) (int64, error) {
+	resp, err := p.Client.GetQueueAttributesWithContext(
+		ctx,
+		&sqs.GetQueueAttributesInput{
+			QueueUrl:       aws.String(p.QueueURL),
+			AttributeNames: []*string{aws.String(sqs.QueueAttributeNameApproximateNumberOfMessages)},
+		},
+	)
 	if err != nil {
-		return numProcessed, err
+		return 0, fmt.Errorf("could not get the number of messages in %v: %v", p.QueueURL, err)
+	}
+
+	n, ok := resp.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]
+	if !ok || n == nil {
+		return 0, fmt.Errorf("%v did not return ApproximateNumberOfMessages", p.QueueURL)
+	}
+
+	return strconv.ParseInt(*n, 10, 64)
+}
+
+// pollerRun is the state shared by every worker in a call to Process
+type pollerRun struct {
+	numEmptyReceives int64
+
+	// remaining is the number of messages left to receive when limited is true
+	limited   bool
+	remaining int64
+
+	deadline time.Time
+
+	// mu guards the messages that failed to sink
+	mu         sync.Mutex
+	numFailed  int
+	firstError error
+}
+
+// sinkFailed counts the messages of a PartialSinkError
+func (r *pollerRun) sinkFailed(err *PartialSinkError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.numFailed += len(err.Failed)
+	if r.firstError == nil {
+		r.firstError = err.Err
+	}
+}
+
+// sinkError returns an error for every message that failed to sink during the run or nil if none did
+func (r *pollerRun) sinkError() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.numFailed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("failed to sink %v messages which were left in the queue. the first error was: %v", r.numFailed, r.firstError)
+}
+
+// reserve claims up to max messages from the remaining budget so that concurrent workers never receive more than
+// MaxMessages between them. It returns 0 when the budget is spent.
+func (r *pollerRun) reserve(max int64) int64 {
+	if !r.limited {
+		return max
+	}
+
+	for {
+		remaining := atomic.LoadInt64(&r.remaining)
+		if remaining <= 0 {
+			return 0
+		}
+
+		n := max
+		if remaining < n {
+			n = remaining
+		}
+
+		if atomic.CompareAndSwapInt64(&r.remaining, remaining, remaining-n) {
+			return n
+		}
 	}
+}
 
-	err = p.deleteMessages(ctx, processed)
-	return numProcessed, err
+// release gives back the part of a reservation that wasn't received
+func (r *pollerRun) release(n int64) {
+	if r.limited && n > 0 {
+		atomic.AddInt64(&r.remaining, n)
+	}
 }
 
-func (p *Poller) receiveMessages(ctx context.Context) ([]*sqs.Message, error) {
+func (p *Poller) receiveMessages(ctx context.Context, maxMessages int64) ([]*sqs.Message, error) {
 	req := &sqs.ReceiveMessageInput{
 		QueueUrl:            aws.String(p.QueueURL),
 		WaitTimeSeconds:     aws.Int64(int64(p.WaitTimeSeconds)),
 		AttributeNames:      []*string{aws.String("ALL")},
-		MaxNumberOfMessages: aws.Int64(p.MaxNumberOfMessages),
+		MaxNumberOfMessages: aws.Int64(maxMessages),
+	}
+
+	if len(p.MessageAttributeNames) > 0 {
+		req.MessageAttributeNames = aws.StringSlice(p.MessageAttributeNames)
+	}
+
+	if p.VisibilityTimeout > 0 {
+		req.VisibilityTimeout = aws.Int64(p.VisibilityTimeout)
 	}
 
 	resp, err := p.Client.ReceiveMessageWithContext(ctx, req)
@@ -108,29 +424,32 @@ func (p *Poller) receiveMessages(ctx context.Context) ([]*sqs.Message, error) {
 }
 
 func (p *Poller) deleteMessages(ctx context.Context, msgs []*sqs.Message) error {
-	entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(msgs))
-
+	// BatchResultErrorEntry only contains the Id of the request and not the ReceiptHandle. We need both to make a
+	// delete request when retrying.
+	receiptHandles := make(map[string]*string, len(msgs))
+	ids := make([]string, len(msgs))
 	for i, msg := range msgs {
-		entries[i] = &sqs.DeleteMessageBatchRequestEntry{
-			Id:            msg.MessageId,
-			ReceiptHandle: msg.ReceiptHandle,
-		}
+		ids[i] = aws.StringValue(msg.MessageId)
+		receiptHandles[ids[i]] = msg.ReceiptHandle
 	}
 
-	failed, err := p.deleteEntries(ctx, entries)
+	failed, err := retryPolicy(p.RetryPolicy).retryBatch(ctx, ids, func(ids []string) ([]*sqs.BatchResultErrorEntry, error) {
+		entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(ids))
+		for i, id := range ids {
+			entries[i] = &sqs.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(id),
+				ReceiptHandle: receiptHandles[id],
+			}
+		}
+
+		return p.deleteEntries(ctx, entries)
+	})
 	if err != nil {
 		return err
 	}
 
-	if senderFault(failed) {
-		return compileFailedErrors("failed to batch delete messages", failed)
-	}
-
 	if len(failed) > 0 {
-		err = p.deleteFailedEntries(ctx, entries, failed, 2)
-		if err != nil {
-			return err
-		}
+		return compileFailedErrors("failed to batch delete messages", failed)
 	}
 
 	return nil
@@ -143,52 +462,11 @@ func (p *Poller) deleteEntries(ctx context.Context, entries []*sqs.DeleteMessage
 	}
 
 	resp, err := p.Client.DeleteMessageBatchWithContext(ctx, req)
-	return resp.Failed, err
-}
-
-// deleteFailedEntries handles retrying messages that failed to send. it is assumed that the messages failed due to availabilty
-// errors with SQS.
-func (p *Poller) deleteFailedEntries(ctx context.Context, entries []*sqs.DeleteMessageBatchRequestEntry, failed []*sqs.BatchResultErrorEntry, maxRetries int) error {
-	// BatchResultErrorEntry only contains the Id of the request and not the ReceiptHandle. We need both to make a delete request.
-	// Let's create a map from the original entry Id to ReceiptHandle
-	entriesMap := make(map[string]string)
-	for _, entry := range entries {
-		entriesMap[*entry.Id] = *entry.ReceiptHandle
-	}
-
-	retries := 0
-	for retries < maxRetries {
-		// Create new batch entries using Id in the ErrorEntry and the corresponding ReceiptHandle from the map we generated above
-		entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(failed))
-		for i, fail := range failed {
-			entry := &sqs.DeleteMessageBatchRequestEntry{
-				Id:            fail.Id,
-				ReceiptHandle: aws.String(entriesMap[*fail.Id]),
-			}
-
-			entries[i] = entry
-		}
-
-		failed, err := p.deleteEntries(ctx, entries)
-		if err != nil {
-			return err
-		}
-
-		// Success!
-		if len(failed) == 0 {
-			return nil
-		}
-
-		// Looks like we still have some more messages that failed to delete. let's loop around again
-		retries++
+	if err != nil {
+		return nil, err
 	}
 
-	// This may or may not be the same slice that was passed in. return a useful error message to the caller
-	// so they can debug.
-	return compileFailedErrors(
-		fmt.Sprintf("unable to delete sqs messages with %v retries", maxRetries),
-		failed,
-	)
+	return resp.Failed, nil
 }
 
 // compileFailedErrors builds an error from all of the separate errors in the failed array
@@ -211,13 +489,29 @@ func compileFailedErrors(msg string, failed []*sqs.BatchResultErrorEntry) error
 	return fmt.Errorf("%v: %v", msg, strings.Join(messages, "\n============\n"))
 }
 
-// senderFault for better or worse assumes if any entry is a sender fault it's likely that the entire batch
-// failed due to a sender error
-func senderFault(failed []*sqs.BatchResultErrorEntry) bool {
-	for _, entry := range failed {
-		if *entry.SenderFault {
-			return true
-		}
-	}
-	return false
+// uncancelableContext keeps the values of its parent but is never cancelled and has no deadline
+type uncancelableContext struct {
+	parent context.Context
+}
+
+// uncancelable returns a context that carries on after ctx has been cancelled. It is used for work that has to
+// finish once it has started, like the batch in flight or the reverse pass of a FallthroughPipeline.
+func uncancelable(ctx context.Context) context.Context {
+	return uncancelableContext{parent: ctx}
+}
+
+func (c uncancelableContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c uncancelableContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c uncancelableContext) Err() error {
+	return nil
+}
+
+func (c uncancelableContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
 }
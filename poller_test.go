@@ -0,0 +1,230 @@
+package sqsdr
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+// recordingHandler keeps the body of every message it handles and how many batches were handled at once
+type recordingHandler struct {
+	delay  time.Duration
+	handle func([]*sqs.Message)
+
+	mu          sync.Mutex
+	bodies      map[string]int
+	inFlight    int
+	maxInFlight int
+}
+
+func (h *recordingHandler) Handle(ctx context.Context, msgs []*sqs.Message) ([]*sqs.Message, error) {
+	h.mu.Lock()
+	h.inFlight++
+	if h.inFlight > h.maxInFlight {
+		h.maxInFlight = h.inFlight
+	}
+	h.mu.Unlock()
+
+	time.Sleep(h.delay)
+	if h.handle != nil {
+		h.handle(msgs)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.inFlight--
+	if h.bodies == nil {
+		h.bodies = make(map[string]int)
+	}
+	for _, msg := range msgs {
+		h.bodies[aws.StringValue(msg.Body)]++
+	}
+
+	return msgs, nil
+}
+
+// handled returns the number of messages handled
+func (h *recordingHandler) handled() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := 0
+	for _, count := range h.bodies {
+		n += count
+	}
+
+	return n
+}
+
+// emptyCounter counts the receives that came back without any messages
+type emptyCounter struct {
+	*sqsdrtest.SQS
+	empty int64
+}
+
+func (e *emptyCounter) ReceiveMessageWithContext(ctx aws.Context, in *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	out, err := e.SQS.ReceiveMessageWithContext(ctx, in, opts...)
+	if err == nil && len(out.Messages) == 0 {
+		atomic.AddInt64(&e.empty, 1)
+	}
+
+	return out, err
+}
+
+// numberedBodies returns msg-0 through msg-(n-1)
+func numberedBodies(n int) []string {
+	bodies := make([]string, n)
+	for i := range bodies {
+		bodies[i] = "msg-" + strconv.Itoa(i)
+	}
+
+	return bodies
+}
+
+func TestPollerConcurrency(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	q := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, q, numberedBodies(100)...)
+
+	h := &recordingHandler{delay: 10 * time.Millisecond}
+	p := NewPoller(q, s, h)
+	p.Concurrency = 4
+
+	err := p.Process(context.Background())
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+
+	for _, body := range numberedBodies(100) {
+		if h.bodies[body] != 1 {
+			t.Errorf("%v was handled %v times; want once", body, h.bodies[body])
+		}
+	}
+
+	if h.maxInFlight < 2 || h.maxInFlight > 4 {
+		t.Errorf("handled %v batches at once; want between 2 and 4", h.maxInFlight)
+	}
+
+	assertBodies(t, s, q)
+}
+
+func TestPollerSharesEmptyReceives(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	q := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, q, numberedBodies(20)...)
+
+	client := &emptyCounter{SQS: s}
+	p := NewPoller(q, client, &recordingHandler{})
+	p.Concurrency = 4
+	p.MaxEmptyReceives = 2
+
+	err := p.Process(context.Background())
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+
+	// Workers that were already receiving when the limit was reached finish their receive. If every worker kept
+	// its own count there would be Concurrency * MaxEmptyReceives empty receives.
+	if client.empty < 2 || client.empty > 2+4-1 {
+		t.Errorf("%v empty receives; want between 2 and 5", client.empty)
+	}
+}
+
+func TestPollerMaxMessagesWithConcurrency(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	q := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, q, numberedBodies(100)...)
+
+	h := &recordingHandler{delay: time.Millisecond}
+	p := NewPoller(q, s, h)
+	p.Concurrency = 4
+	p.StopConditions.MaxMessages = 25
+
+	err := p.Process(context.Background())
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+
+	if n := h.handled(); n != 25 {
+		t.Errorf("handled %v messages; want 25", n)
+	}
+
+	if n := len(s.Messages(q)); n != 75 {
+		t.Errorf("%v messages left in the queue; want 75", n)
+	}
+}
+
+func TestPollerTimeout(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	q := s.MustCreateQueue("orders-dlq")
+
+	// Without the timeout an empty queue would be polled forever
+	p := NewPoller(q, s, &recordingHandler{})
+	p.Concurrency = 4
+	p.MaxEmptyReceives = 1 << 30
+	p.StopConditions.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	err := p.Process(context.Background())
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Process took %v to stop", took)
+	}
+}
+
+func TestPollerStopAfterSnapshot(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	q := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, q, numberedBodies(30)...)
+
+	// A producer that keeps writing to the queue means it is never empty
+	h := &recordingHandler{handle: func(msgs []*sqs.Message) {
+		for range msgs {
+			s.MustSendMessage(q, "new")
+		}
+	}}
+
+	p := NewPoller(q, s, h)
+	p.Concurrency = 3
+	p.StopConditions.StopAfterSnapshot = true
+
+	err := p.Process(context.Background())
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+
+	if n := h.handled(); n != 30 {
+		t.Errorf("handled %v messages; want the 30 that were in the queue when it started", n)
+	}
+
+	if n := len(s.Messages(q)); n != 30 {
+		t.Errorf("%v messages left in the queue; want 30", n)
+	}
+
+	// The smaller of MaxMessages and the snapshot wins
+	h = &recordingHandler{}
+	p = NewPoller(q, s, h)
+	p.StopConditions.StopAfterSnapshot = true
+	p.StopConditions.MaxMessages = 12
+
+	err = p.Process(context.Background())
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+
+	if n := h.handled(); n != 12 {
+		t.Errorf("handled %v messages; want 12", n)
+	}
+}
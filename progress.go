@@ -0,0 +1,130 @@
+package sqsdr
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Observer is told about the messages moving through a Poller and a Pipeline. Methods are called from every worker
+// so implementations must be safe for concurrent use. Progress is an Observer that keeps running totals.
+type Observer interface {
+	// Started is called once by each call to Poller.Process with the ApproximateNumberOfMessages in the queue, or -1
+	// if it couldn't be read
+	Started(approximateNumberOfMessages int64)
+
+	// Received is called by the Poller after each non-empty receive
+	Received(n int)
+
+	// Chosen is called by the Pipeline with the number of messages headed for the left and right sinks
+	Chosen(left int, right int)
+
+	// Deleted is called by the Poller after messages have been deleted from the queue
+	Deleted(n int)
+
+	// Failed is called by the Poller with the number of messages in a batch that the Handler or the delete failed on
+	Failed(n int)
+}
+
+// Progress is an Observer that counts messages and works out the throughput and an ETA. Pass the same Progress to
+// the Poller and the Pipeline and read it with Snapshot while they run.
+type Progress struct {
+	received int64
+	left     int64
+	right    int64
+	deleted  int64
+	failed   int64
+
+	mu        sync.Mutex
+	startedAt time.Time
+	total     int64
+}
+
+// ProgressSnapshot is a point in time copy of a Progress
+type ProgressSnapshot struct {
+	Received int64
+	Left     int64
+	Right    int64
+	Deleted  int64
+	Failed   int64
+
+	// ApproximateNumberOfMessages is the size of the queue when the first Poller started. It is -1 when unknown.
+	ApproximateNumberOfMessages int64
+
+	Elapsed time.Duration
+
+	// Throughput is the number of messages received per second
+	Throughput float64
+
+	// ETA is how much longer receiving the rest of ApproximateNumberOfMessages should take at the current
+	// throughput. It is zero when it can't be worked out.
+	ETA time.Duration
+}
+
+// Started records the start time and queue size of the first Poller. Later calls are ignored so that a second pass,
+// like the reverse pass of a FallthroughPipeline, doesn't reset the totals.
+func (p *Progress) Started(approximateNumberOfMessages int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.startedAt.IsZero() {
+		return
+	}
+
+	p.startedAt = time.Now()
+	p.total = approximateNumberOfMessages
+}
+
+// Received adds to the received count
+func (p *Progress) Received(n int) {
+	atomic.AddInt64(&p.received, int64(n))
+}
+
+// Chosen adds to the left and right counts
+func (p *Progress) Chosen(left int, right int) {
+	atomic.AddInt64(&p.left, int64(left))
+	atomic.AddInt64(&p.right, int64(right))
+}
+
+// Deleted adds to the deleted count
+func (p *Progress) Deleted(n int) {
+	atomic.AddInt64(&p.deleted, int64(n))
+}
+
+// Failed adds to the failed count
+func (p *Progress) Failed(n int) {
+	atomic.AddInt64(&p.failed, int64(n))
+}
+
+// Snapshot returns the counts so far along with the throughput and ETA
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	startedAt, total := p.startedAt, p.total
+	p.mu.Unlock()
+
+	s := ProgressSnapshot{
+		Received:                    atomic.LoadInt64(&p.received),
+		Left:                        atomic.LoadInt64(&p.left),
+		Right:                       atomic.LoadInt64(&p.right),
+		Deleted:                     atomic.LoadInt64(&p.deleted),
+		Failed:                      atomic.LoadInt64(&p.failed),
+		ApproximateNumberOfMessages: -1,
+	}
+
+	if startedAt.IsZero() {
+		return s
+	}
+
+	s.ApproximateNumberOfMessages = total
+	s.Elapsed = time.Since(startedAt)
+	if s.Elapsed > 0 {
+		s.Throughput = float64(s.Received) / s.Elapsed.Seconds()
+	}
+
+	remaining := total - s.Received
+	if total > 0 && remaining > 0 && s.Throughput > 0 {
+		s.ETA = time.Duration(float64(remaining) / s.Throughput * float64(time.Second))
+	}
+
+	return s
+}
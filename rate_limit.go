@@ -0,0 +1,172 @@
+package sqsdr
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// RateStep runs a RateLimiter at Rate messages per second for Duration
+type RateStep struct {
+	Rate     float64
+	Duration time.Duration
+}
+
+// ParseRateStep parses a step of the form <messages per second>:<duration> like 10:1m
+func ParseRateStep(step string) (RateStep, error) {
+	parts := strings.SplitN(step, ":", 2)
+	if len(parts) != 2 {
+		return RateStep{}, fmt.Errorf("rate step '%v' must look like <messages per second>:<duration>", step)
+	}
+
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate <= 0 {
+		return RateStep{}, fmt.Errorf("rate step '%v' needs a rate greater than 0", step)
+	}
+
+	duration, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return RateStep{}, fmt.Errorf("could not parse the duration of rate step '%v': %v", step, err)
+	}
+
+	return RateStep{Rate: rate, Duration: duration}, nil
+}
+
+// RateLimiter is a token bucket that lets Rate messages per second through. A single RateLimiter can be shared by
+// every worker of a Poller. The bucket holds a second's worth of tokens so short bursts are smoothed out.
+//
+// RampUp optionally starts slower. Each step runs in order from the first call to Wait and Rate takes over once
+// they're done.
+type RateLimiter struct {
+	// Rate is in messages per second. Zero or less means unlimited.
+	Rate float64
+
+	RampUp []RateStep
+
+	mu      sync.Mutex
+	started time.Time
+	last    time.Time
+	tokens  float64
+}
+
+// Wait blocks until n messages are allowed through or the context is done. Batches larger than the bucket are let
+// through once the tokens they borrowed have been paid back, so the rate holds on average.
+func (r *RateLimiter) Wait(ctx context.Context, n int) error {
+	now := time.Now()
+
+	r.mu.Lock()
+	if r.started.IsZero() {
+		r.started = now
+		r.last = now
+		r.tokens = burst(r.rateAt(now))
+	}
+
+	rate := r.rateAt(now)
+	if rate <= 0 {
+		r.mu.Unlock()
+		return nil
+	}
+
+	r.tokens += rate * now.Sub(r.last).Seconds()
+	if max := burst(rate); r.tokens > max {
+		r.tokens = max
+	}
+	r.last = now
+
+	r.tokens -= float64(n)
+	deficit := -r.tokens
+	r.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / rate * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Return gives back the tokens of n messages that were waited for but never sent, like the part of a receive that
+// came back short
+func (r *RateLimiter) Return(n int) {
+	if n <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started.IsZero() {
+		return
+	}
+
+	r.tokens += float64(n)
+	if max := burst(r.rateAt(time.Now())); r.tokens > max {
+		r.tokens = max
+	}
+}
+
+// rateAt returns the rate of the ramp up step that now falls in, or Rate once the ramp up is over. Callers must
+// hold the lock.
+func (r *RateLimiter) rateAt(now time.Time) float64 {
+	elapsed := now.Sub(r.started)
+	for _, step := range r.RampUp {
+		if elapsed < step.Duration {
+			return step.Rate
+		}
+
+		elapsed -= step.Duration
+	}
+
+	return r.Rate
+}
+
+// burst is the size of the bucket at a rate
+func burst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+
+	return rate
+}
+
+// RateLimitedSink waits on the Limiter before passing messages through to Passthrough
+type RateLimitedSink struct {
+	Limiter     *RateLimiter
+	Passthrough Sinker
+}
+
+// Sink blocks until the Limiter lets the messages through and then sinks them into Passthrough
+func (r *RateLimitedSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
+	err := r.Limiter.Wait(ctx, len(msgs))
+	if err != nil {
+		return err
+	}
+
+	return r.Passthrough.Sink(ctx, msgs)
+}
+
+// rateReturnSink gives the tokens of the messages it sinks back to the Limiter. A Poller with a RateLimiter waits
+// for every message it receives so this keeps the messages that never reach the rate limited destination, like
+// those a filtered redrive keeps, from counting against the rate.
+type rateReturnSink struct {
+	Limiter     *RateLimiter
+	Passthrough Sinker
+}
+
+// Sink sinks the messages into Passthrough and returns their tokens
+func (r *rateReturnSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
+	defer r.Limiter.Return(len(msgs))
+	return r.Passthrough.Sink(ctx, msgs)
+}
@@ -0,0 +1,169 @@
+package sqsdr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+// timeWait returns how long Wait took
+func timeWait(t *testing.T, r *RateLimiter, n int) time.Duration {
+	t.Helper()
+
+	start := time.Now()
+	err := r.Wait(context.Background(), n)
+	if err != nil {
+		t.Fatalf("Wait returned an error: %v", err)
+	}
+
+	return time.Since(start)
+}
+
+// assertWaited fails the test unless took is within a generous margin of want
+func assertWaited(t *testing.T, took time.Duration, want time.Duration) {
+	t.Helper()
+
+	if took < want-want/5 || took > want+200*time.Millisecond {
+		t.Errorf("waited %v; want about %v", took, want)
+	}
+}
+
+func TestParseRateStep(t *testing.T) {
+	tests := []struct {
+		step    string
+		want    RateStep
+		wantErr bool
+	}{
+		{step: "10:1m", want: RateStep{Rate: 10, Duration: time.Minute}},
+		{step: "0.5:90s", want: RateStep{Rate: 0.5, Duration: 90 * time.Second}},
+		{step: "10", wantErr: true},
+		{step: "0:1m", wantErr: true},
+		{step: "fast:1m", wantErr: true},
+		{step: "10:soon", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.step, func(t *testing.T) {
+			got, err := ParseRateStep(test.step)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseRateStep returned %v; want an error", got)
+				}
+				return
+			}
+
+			if err != nil || got != test.want {
+				t.Errorf("ParseRateStep = %v, %v; want %v", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRate(t *testing.T) {
+	r := &RateLimiter{Rate: 200}
+
+	// The bucket starts with a second's worth of tokens
+	assertWaited(t, timeWait(t, r, 200), 0)
+
+	// Then it refills at the rate
+	assertWaited(t, timeWait(t, r, 50), 250*time.Millisecond)
+	assertWaited(t, timeWait(t, r, 20), 100*time.Millisecond)
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	r := &RateLimiter{}
+	for i := 0; i < 3; i++ {
+		assertWaited(t, timeWait(t, r, 1000), 0)
+	}
+}
+
+func TestRateLimiterReturn(t *testing.T) {
+	r := &RateLimiter{Rate: 10}
+	assertWaited(t, timeWait(t, r, 10), 0)
+
+	// The tokens of messages that were never sent are free to use again
+	r.Return(10)
+	assertWaited(t, timeWait(t, r, 10), 0)
+
+	// The bucket never holds more than a second's worth
+	r.Return(100)
+	assertWaited(t, timeWait(t, r, 15), 500*time.Millisecond)
+}
+
+func TestRateLimiterRampUp(t *testing.T) {
+	r := &RateLimiter{Rate: 1000, RampUp: []RateStep{{Rate: 20, Duration: time.Hour}}}
+
+	// The first step sets the size of the bucket and the rate it refills at
+	assertWaited(t, timeWait(t, r, 20), 0)
+	assertWaited(t, timeWait(t, r, 5), 250*time.Millisecond)
+}
+
+func TestRateLimiterRampUpSteps(t *testing.T) {
+	r := &RateLimiter{
+		Rate:   200,
+		RampUp: []RateStep{{Rate: 10, Duration: time.Minute}, {Rate: 50, Duration: 2 * time.Minute}},
+	}
+	r.started = time.Now()
+
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 10},
+		{59 * time.Second, 10},
+		{time.Minute, 50},
+		{2*time.Minute + 59*time.Second, 50},
+		{3 * time.Minute, 200},
+		{time.Hour, 200},
+	}
+
+	for _, test := range tests {
+		if got := r.rateAt(r.started.Add(test.elapsed)); got != test.want {
+			t.Errorf("rate after %v = %v; want %v", test.elapsed, got, test.want)
+		}
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	r := &RateLimiter{Rate: 1}
+	assertWaited(t, timeWait(t, r, 1), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := r.Wait(ctx, 100)
+	if err != context.Canceled {
+		t.Errorf("Wait returned %v; want %v", err, context.Canceled)
+	}
+
+	assertWaited(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestRedriveCancelWhileRateLimited(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, "one", "two", "three")
+
+	// The first receive has to wait for nine seconds worth of tokens
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest, RateLimiter: &RateLimiter{Rate: 1}}
+	err := r.Redrive(ctx)
+	if err != context.Canceled {
+		t.Errorf("Redrive returned %v; want %v", err, context.Canceled)
+	}
+
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Redrive took %v to stop", took)
+	}
+
+	// Nothing was received while waiting so every message is still visible in the source
+	assertBodies(t, s, dest)
+	assertBodies(t, s, src, "one", "two", "three")
+}
@@ -0,0 +1,140 @@
+package sqsdr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	// maxListQueuesResults is the most queues SQS returns from a single ListQueues
+	maxListQueuesResults = 1000
+)
+
+// Recover finds fallthrough queues left behind by a FallthroughPipeline that failed or was killed, redrives their
+// messages back into the source queue they came from, and removes them.
+//
+// Don't run Recover while a redrive or dump is still running against the same source queue. It can't tell a
+// stranded fallthrough queue from one that is in use.
+type Recover struct {
+	Client sqsiface.SQSAPI
+
+	// SourceQueueURL optionally limits recovery to the fallthrough queue of a single source queue
+	SourceQueueURL string
+
+	// Concurrency is the number of workers polling each fallthrough queue
+	Concurrency int
+}
+
+// Recover is the entry point into the recover strategy. Cancelling ctx stops after the batches in flight and leaves
+// the fallthrough queue being recovered in place. Fallthrough queues whose source queue can't be found are skipped
+// and listed in the returned error once the rest have been recovered.
+func (r *Recover) Recover(ctx context.Context) error {
+	queueURLs, err := r.fallthroughQueues()
+	if err != nil {
+		return err
+	}
+
+	if len(queueURLs) == 0 {
+		log.Println("no fallthrough queues to recover")
+		return nil
+	}
+
+	var skipped []string
+	for _, queueURL := range queueURLs {
+		// The fallthrough queue is named after its source so unrelated queues are skipped without looking them up
+		if r.SourceQueueURL != "" && sourceQueueNameFromFallthrough(queueURL) != queueNameFromURL(r.SourceQueueURL) {
+			continue
+		}
+
+		sourceQueueURL, startedAt, err := r.sourceQueue(queueURL)
+		if err != nil {
+			log.Printf("skipping %v: %v\n", queueURL, err)
+			skipped = append(skipped, queueURL)
+			continue
+		}
+
+		if r.SourceQueueURL != "" && r.SourceQueueURL != sourceQueueURL {
+			continue
+		}
+
+		log.Printf("recovering %v into %v (started at: %v)\n", queueURL, sourceQueueURL, startedAt)
+		err = redriveFallthroughQueue(ctx, r.Client, queueURL, sourceQueueURL, r.Concurrency)
+		if err != nil {
+			return fmt.Errorf("could not redrive %v back into %v: %v", queueURL, sourceQueueURL, err)
+		}
+
+		log.Println("removing fallthrough queue", queueURL)
+		err = deleteFallthroughQueue(r.Client, queueURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(skipped) > 0 {
+		return fmt.Errorf("could not find the source queue of %v fallthrough queues: %v", len(skipped), strings.Join(skipped, ", "))
+	}
+
+	return nil
+}
+
+// fallthroughQueues lists every queue that looks like it was created by createFallthroughQueue. ListQueues returns at
+// most 1000 queues so the list is narrowed down to the fallthrough queue of SourceQueueURL when it is set.
+func (r *Recover) fallthroughQueues() ([]string, error) {
+	prefix := fallthroughQueuePrefix
+	if r.SourceQueueURL != "" {
+		prefix = strings.TrimSuffix(fallthroughQueueName(r.SourceQueueURL), fifoSuffix)
+	}
+
+	resp, err := r.Client.ListQueues(
+		&sqs.ListQueuesInput{QueueNamePrefix: aws.String(prefix)},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not list fallthrough queues: %v", err)
+	}
+
+	if len(resp.QueueUrls) >= maxListQueuesResults {
+		log.Printf("found %v queues starting with %v which is as many as SQS lists. run recover with --source for each source queue to find the rest\n", len(resp.QueueUrls), prefix)
+	}
+
+	queueURLs := make([]string, 0, len(resp.QueueUrls))
+	for _, queueURL := range resp.QueueUrls {
+		if isFallthroughQueue(*queueURL) {
+			queueURLs = append(queueURLs, *queueURL)
+		}
+	}
+
+	return queueURLs, nil
+}
+
+// sourceQueue returns the source queue URL and start time from the fallthrough queue's tags. Queues without tags
+// fall back to looking up the source queue by the name of the fallthrough queue.
+func (r *Recover) sourceQueue(queueURL string) (string, string, error) {
+	startedAt := "unknown"
+
+	resp, err := r.Client.ListQueueTags(&sqs.ListQueueTagsInput{QueueUrl: aws.String(queueURL)})
+	if err != nil {
+		log.Printf("could not list tags for %v: %v\n", queueURL, err)
+	} else {
+		if t, ok := resp.Tags[startedAtTag]; ok && t != nil {
+			startedAt = *t
+		}
+
+		if u, ok := resp.Tags[sourceQueueURLTag]; ok && u != nil {
+			return *u, startedAt, nil
+		}
+	}
+
+	sourceQueueName := sourceQueueNameFromFallthrough(queueURL)
+	output, err := r.Client.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(sourceQueueName)})
+	if err != nil {
+		return "", "", fmt.Errorf("could not find source queue '%v' for fallthrough queue %v: %v", sourceQueueName, queueURL, err)
+	}
+
+	return *output.QueueUrl, startedAt, nil
+}
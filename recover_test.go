@@ -0,0 +1,137 @@
+package sqsdr
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+// strandMessages creates the fallthrough queue of the source queue and sinks the bodies into it the way a
+// FallthroughPipeline would
+func strandMessages(t *testing.T, s *sqsdrtest.SQS, src string, bodies ...string) string {
+	t.Helper()
+
+	fallthroughQueueURL, err := createFallthroughQueue(s, src, time.Now())
+	if err != nil {
+		t.Fatalf("createFallthroughQueue returned an error: %v", err)
+	}
+
+	msgs := make([]*sqs.Message, len(bodies))
+	for i, body := range bodies {
+		msgs[i] = &sqs.Message{MessageId: aws.String(strconv.Itoa(i)), Body: aws.String(body)}
+	}
+
+	sink := &SQSSink{QueueURL: fallthroughQueueURL, Client: s}
+	err = sink.Sink(context.Background(), msgs)
+	if err != nil {
+		t.Fatalf("could not strand messages: %v", err)
+	}
+
+	return fallthroughQueueURL
+}
+
+func TestRecover(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	orders := s.MustCreateQueue("orders-dlq")
+	payments := s.MustCreateQueue("payments-dlq")
+	sendBodies(s, orders, "already there")
+	strandMessages(t, s, orders, "order-1", "order-2")
+	strandMessages(t, s, payments, "payment-1")
+
+	r := &Recover{Client: s}
+	err := r.Recover(context.Background())
+	if err != nil {
+		t.Fatalf("Recover returned an error: %v", err)
+	}
+
+	assertBodies(t, s, orders, "already there", "order-1", "order-2")
+	assertBodies(t, s, payments, "payment-1")
+	assertNoFallthroughQueues(t, s)
+}
+
+func TestRecoverOnlyTheSourceQueue(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	orders := s.MustCreateQueue("orders-dlq")
+	payments := s.MustCreateQueue("payments-dlq")
+	strandMessages(t, s, orders, "order-1")
+	paymentsFallthrough := strandMessages(t, s, payments, "payment-1")
+
+	// A fallthrough queue whose source is gone is skipped without being looked up
+	s.MustCreateQueue("sqsdr-deleted-dlq-fallthrough")
+
+	r := &Recover{Client: s, SourceQueueURL: orders}
+	err := r.Recover(context.Background())
+	if err != nil {
+		t.Fatalf("Recover returned an error: %v", err)
+	}
+
+	assertBodies(t, s, orders, "order-1")
+	assertBodies(t, s, paymentsFallthrough, "payment-1")
+}
+
+func TestRecoverSourceQueueAmongManyFallthroughQueues(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+
+	// More fallthrough queues than ListQueues returns, all listed before the one we want
+	for i := 0; i < 1000; i++ {
+		s.MustCreateQueue("sqsdr-another-" + strconv.Itoa(i) + "-fallthrough")
+	}
+
+	orders := s.MustCreateQueue("orders-dlq")
+	strandMessages(t, s, orders, "order-1")
+
+	r := &Recover{Client: s, SourceQueueURL: orders}
+	err := r.Recover(context.Background())
+	if err != nil {
+		t.Fatalf("Recover returned an error: %v", err)
+	}
+
+	assertBodies(t, s, orders, "order-1")
+	for _, queueURL := range s.QueueURLs() {
+		if strings.Contains(queueURL, "orders-dlq-fallthrough") {
+			t.Errorf("%v was not removed", queueURL)
+		}
+	}
+}
+
+func TestRecoverSkipsOrphanedQueues(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	orders := s.MustCreateQueue("orders-dlq")
+	strandMessages(t, s, orders, "order-1")
+	orphan := s.MustCreateQueue("sqsdr-deleted-dlq-fallthrough")
+	sendBodies(s, orphan, "lost")
+
+	r := &Recover{Client: s}
+	err := r.Recover(context.Background())
+	if err == nil || !strings.Contains(err.Error(), orphan) {
+		t.Fatalf("Recover returned %v; want an error naming %v", err, orphan)
+	}
+
+	// The rest are still recovered and the orphan is left alone
+	assertBodies(t, s, orders, "order-1")
+	assertBodies(t, s, orphan, "lost")
+}
+
+func TestRecoverFIFO(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	orders := s.MustCreateQueue("orders-dlq.fifo")
+	strandMessages(t, s, orders, "one", "two", "three")
+
+	r := &Recover{Client: s}
+	err := r.Recover(context.Background())
+	if err != nil {
+		t.Fatalf("Recover returned an error: %v", err)
+	}
+
+	if got := queueBodies(s, orders); strings.Join(got, ",") != "one,two,three" {
+		t.Errorf("source has %v; want the messages in their order", got)
+	}
+
+	assertNoFallthroughQueues(t, s)
+}
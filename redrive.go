@@ -4,67 +4,107 @@ import (
 	"context"
 	"log"
 
-	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
 // Redrive is a simple strategy that moves messages from a source queue to a destination queue.
 type Redrive struct {
-	SourceClient   *sqs.SQS
+	SourceClient   sqsiface.SQSAPI
 	SourceQueueURL string
 
-	DestClient   *sqs.SQS
+	DestClient   sqsiface.SQSAPI
 	DestQueueURL string
 
 	JMESPath string
 	Regex    string
 
-	// Not implemented yet
-	concurrency int
+	// Chooser optionally replaces the JMESPath and Regex filter. Messages it sends to the left are redriven
+	// and all others stay in the source queue.
+	Chooser Chooser
+
+	// Transformer optionally rewrites messages on their way to the destination queue. Messages that fail to
+	// transform stay in the source queue.
+	Transformer Transformer
+
+	// Concurrency is the number of workers polling the source queue
+	Concurrency int
+
+	// RateLimiter optionally limits how fast messages are sent to the destination queue. It is shared by every
+	// worker and waited on before each receive.
+	RateLimiter *RateLimiter
+
+	// Observer is optionally told how many messages were received, redriven, and left in the source queue
+	Observer Observer
+
+	// Resolver optionally fetches offloaded bodies so that the Chooser and the JMESPath and Regex filter see the
+	// real ones. Redriven messages keep their pointers.
+	Resolver PayloadResolver
+
+	StopConditions
+	Heartbeat
 }
 
-// Redrive is the entry point into the redriving strategy
-func (r *Redrive) Redrive() error {
-	if r.Regex != "" {
-		return r.filteredRedrive()
+// Redrive is the entry point into the redriving strategy. Cancelling ctx stops after the batches in flight. See
+// FallthroughPipeline.Run for how a filtered redrive stops.
+func (r *Redrive) Redrive(ctx context.Context) error {
+	// Messages that fail to transform need the fallthrough queue to make it back to the source
+	if r.Chooser != nil || r.Regex != "" || r.Transformer != nil {
+		return r.filteredRedrive(ctx)
 	}
 
-	return r.simpleRedrive()
+	return r.simpleRedrive(ctx)
 }
 
-func (r *Redrive) simpleRedrive() error {
+func (r *Redrive) simpleRedrive(ctx context.Context) error {
 	log.Println("starting simple redrive")
-	sink := &SQSSink{QueueURL: r.DestQueueURL, Client: r.DestClient}
-
 	pipeline := &Pipeline{
 		Chooser:   &PassthroughChooser{},
-		LeftSink:  sink,
+		LeftSink:  &SQSSink{QueueURL: r.DestQueueURL, Client: r.DestClient},
 		RightSink: NoOpSink{},
+		Observer:  r.Observer,
 	}
 
 	poller := NewPoller(r.SourceQueueURL, r.SourceClient, pipeline)
+	poller.Concurrency = r.Concurrency
+	poller.StopConditions = r.StopConditions
+	poller.Heartbeat = r.Heartbeat
+	poller.Observer = r.Observer
+	poller.RateLimiter = r.RateLimiter
 
-	return poller.Process(context.Background())
+	return poller.Process(ctx)
 }
 
-func (r *Redrive) filteredRedrive() error {
-	chooser, err := NewFilterChooser(r.JMESPath, r.Regex)
-	if err != nil {
-		return err
+func (r *Redrive) filteredRedrive(ctx context.Context) error {
+	chooser := r.Chooser
+	if chooser == nil && r.Regex == "" {
+		chooser = &PassthroughChooser{}
+	} else if chooser == nil {
+		filter, err := NewFilterChooser(r.JMESPath, r.Regex)
+		if err != nil {
+			return err
+		}
+
+		chooser = filter
 	}
 
-	leftSink := &SQSSink{QueueURL: r.DestQueueURL, Client: r.DestClient}
 	rightSinkFunc := func(queueURL string, client sqsiface.SQSAPI) Sinker {
 		return &SQSSink{QueueURL: queueURL, Client: client}
 	}
 
 	f := FallthroughPipeline{
 		Chooser:        chooser,
-		LeftSink:       leftSink,
+		LeftSink:       &SQSSink{QueueURL: r.DestQueueURL, Client: r.DestClient},
 		RightSinkFunc:  rightSinkFunc,
 		SourceClient:   r.SourceClient,
 		SourceQueueURL: r.SourceQueueURL,
+		Transformer:    r.Transformer,
+		Concurrency:    r.Concurrency,
+		Observer:       r.Observer,
+		Resolver:       r.Resolver,
+		RateLimiter:    r.RateLimiter,
+		StopConditions: r.StopConditions,
+		Heartbeat:      r.Heartbeat,
 	}
 
-	return f.Run()
+	return f.Run(ctx)
 }
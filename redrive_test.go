@@ -0,0 +1,330 @@
+package sqsdr
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+func TestSimpleRedrive(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, "one", "two", "three")
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest}
+	err := r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	assertBodies(t, s, src)
+	assertBodies(t, s, dest, "one", "two", "three")
+	assertNoFallthroughQueues(t, s)
+}
+
+func TestSimpleRedriveStopsAtMaxMessages(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, "one", "two", "three")
+
+	r := &Redrive{
+		SourceClient:   s,
+		SourceQueueURL: src,
+		DestClient:     s,
+		DestQueueURL:   dest,
+		StopConditions: StopConditions{MaxMessages: 2},
+	}
+	err := r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	assertBodies(t, s, src, "three")
+	assertBodies(t, s, dest, "one", "two")
+}
+
+func TestFilteredRedrive(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, `{"status":"retry","id":1}`, `{"status":"drop","id":2}`, `{"status":"retry","id":3}`, "not json")
+
+	r := &Redrive{
+		SourceClient:   s,
+		SourceQueueURL: src,
+		DestClient:     s,
+		DestQueueURL:   dest,
+		JMESPath:       "status",
+		Regex:          "retry",
+	}
+	err := r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	assertBodies(t, s, dest, `{"status":"retry","id":1}`, `{"status":"retry","id":3}`)
+	assertBodies(t, s, src, `{"status":"drop","id":2}`, "not json")
+	assertNoFallthroughQueues(t, s)
+}
+
+func TestRedriveWithTransformer(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, `{"id":1}`, "not json")
+
+	transformer, err := ParseTransformExpression(`merge:{"retried":true}`)
+	if err != nil {
+		t.Fatalf("ParseTransformExpression returned an error: %v", err)
+	}
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest, Transformer: transformer}
+	err = r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	assertBodies(t, s, dest, `{"id":1,"retried":true}`)
+	assertBodies(t, s, src, "not json")
+	assertNoFallthroughQueues(t, s)
+}
+
+func TestRedriveFIFO(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq.fifo")
+	dest := s.MustCreateQueue("orders.fifo")
+
+	for _, m := range []struct{ group, body string }{{"a", "a1"}, {"b", "b1"}, {"a", "a2"}, {"b", "b2"}, {"a", "a3"}} {
+		_, err := s.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:       aws.String(src),
+			MessageBody:    aws.String(m.body),
+			MessageGroupId: aws.String(m.group),
+		})
+		if err != nil {
+			t.Fatalf("SendMessage returned an error: %v", err)
+		}
+	}
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest}
+	err := r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	assertBodies(t, s, src)
+
+	groups := make(map[string][]string)
+	for _, msg := range s.Messages(dest) {
+		group := aws.StringValue(msg.Attributes["MessageGroupId"])
+		groups[group] = append(groups[group], aws.StringValue(msg.Body))
+	}
+
+	want := map[string][]string{"a": {"a1", "a2", "a3"}, "b": {"b1", "b2"}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("destination groups = %v; want %v", groups, want)
+	}
+}
+
+func TestFilteredRedriveFIFO(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq.fifo")
+	dest := s.MustCreateQueue("orders.fifo")
+
+	for _, m := range []struct{ group, body string }{{"a", "keep-1"}, {"a", "move-1"}, {"b", "move-2"}, {"a", "keep-2"}} {
+		_, err := s.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:       aws.String(src),
+			MessageBody:    aws.String(m.body),
+			MessageGroupId: aws.String(m.group),
+		})
+		if err != nil {
+			t.Fatalf("SendMessage returned an error: %v", err)
+		}
+	}
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest, Regex: "move"}
+	err := r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	assertBodies(t, s, dest, "move-1", "move-2")
+	assertNoFallthroughQueues(t, s)
+
+	// The messages that stayed keep their group and their order
+	msgs := s.Messages(src)
+	if len(msgs) != 2 || aws.StringValue(msgs[0].Body) != "keep-1" || aws.StringValue(msgs[1].Body) != "keep-2" {
+		t.Fatalf("source has %v; want keep-1 then keep-2", queueBodies(s, src))
+	}
+
+	for _, msg := range msgs {
+		if group := aws.StringValue(msg.Attributes["MessageGroupId"]); group != "a" {
+			t.Errorf("%v is in group %q; want a", aws.StringValue(msg.Body), group)
+		}
+	}
+}
+
+func TestSimpleRedrivePartialFailure(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, "one", "bad", "three")
+	s.FailSendEntry = failBodies("bad")
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest}
+	err := r.Redrive(context.Background())
+	if err == nil {
+		t.Fatal("Redrive did not return the send failure")
+	}
+
+	// Only the message that wasn't sent is left to be redriven again
+	assertBodies(t, s, dest, "one", "three")
+	assertBodies(t, s, src, "bad")
+}
+
+func TestRedriveKeepsGoingAfterPartialFailure(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+
+	// bad is in the first batch of 10. The two batches after it still have to be redriven.
+	want := make([]string, 0, 24)
+	sendBodies(s, src, "bad")
+	for i := 1; i < 25; i++ {
+		body := "msg-" + strconv.Itoa(i)
+		sendBodies(s, src, body)
+		want = append(want, body)
+	}
+	s.FailSendEntry = failBodies("bad")
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest}
+	err := r.Redrive(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to sink 1 messages") {
+		t.Fatalf("Redrive returned %v; want the one failed message", err)
+	}
+
+	assertBodies(t, s, dest, want...)
+	assertBodies(t, s, src, "bad")
+}
+
+func TestFilteredRedrivePartialFailurePutsFallthroughBack(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, "move-1", "move-bad", "keep-1")
+	s.FailSendEntry = func(queueURL string, entry *sqs.SendMessageBatchRequestEntry) *sqs.BatchResultErrorEntry {
+		if queueURL != dest {
+			return nil
+		}
+
+		return failBodies("move-bad")(queueURL, entry)
+	}
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest, Regex: "move"}
+	err := r.Redrive(context.Background())
+	if err == nil {
+		t.Fatal("Redrive did not return the send failure")
+	}
+
+	if strings.Contains(err.Error(), "sqsdr recover") {
+		t.Errorf("Redrive asked for a recover after putting the fallthrough queue back: %v", err)
+	}
+
+	// keep-1 is put back by the reverse pass even though the forward pass failed
+	assertBodies(t, s, dest, "move-1")
+	assertBodies(t, s, src, "move-bad", "keep-1")
+	assertNoFallthroughQueues(t, s)
+}
+
+func TestFilteredRedriveReverseFailureCanBeRecovered(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, "move-1", "keep-1")
+	s.FailSendEntry = func(queueURL string, entry *sqs.SendMessageBatchRequestEntry) *sqs.BatchResultErrorEntry {
+		if queueURL != src {
+			return nil
+		}
+
+		return failBodies("keep-1")(queueURL, entry)
+	}
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest, Regex: "move"}
+	err := r.Redrive(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "sqsdr recover") {
+		t.Fatalf("Redrive returned %v; want the recover instructions", err)
+	}
+
+	assertBodies(t, s, dest, "move-1")
+	assertBodies(t, s, src)
+
+	// keep-1 was stranded in the fallthrough queue when the reverse pass failed
+	s.FailSendEntry = nil
+	s.Advance(time.Hour)
+	rec := &Recover{Client: s, SourceQueueURL: src}
+	err = rec.Recover(context.Background())
+	if err != nil {
+		t.Fatalf("Recover returned an error: %v", err)
+	}
+
+	assertBodies(t, s, src, "keep-1")
+	assertNoFallthroughQueues(t, s)
+}
+
+func TestRedriveRetriesThrottledSends(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, "one", "two")
+
+	throttled := false
+	s.FailSendEntry = func(queueURL string, entry *sqs.SendMessageBatchRequestEntry) *sqs.BatchResultErrorEntry {
+		if throttled || aws.StringValue(entry.MessageBody) != "two" {
+			return nil
+		}
+
+		throttled = true
+		return &sqs.BatchResultErrorEntry{Code: aws.String("RequestThrottled"), SenderFault: aws.Bool(true)}
+	}
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest}
+	err := r.Redrive(context.Background())
+	if err != nil {
+		t.Fatalf("Redrive returned an error: %v", err)
+	}
+
+	if !throttled {
+		t.Error("the send was never throttled")
+	}
+
+	assertBodies(t, s, src)
+	assertBodies(t, s, dest, "one", "two")
+}
+
+func TestRedriveWithFailedDeletes(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	dest := s.MustCreateQueue("orders")
+	sendBodies(s, src, "one")
+	s.FailDeleteEntry = func(queueURL string, entry *sqs.DeleteMessageBatchRequestEntry) *sqs.BatchResultErrorEntry {
+		return &sqs.BatchResultErrorEntry{Code: aws.String("ReceiptHandleIsInvalid"), SenderFault: aws.Bool(true)}
+	}
+
+	r := &Redrive{SourceClient: s, SourceQueueURL: src, DestClient: s, DestQueueURL: dest}
+	err := r.Redrive(context.Background())
+	if err == nil {
+		t.Fatal("Redrive did not return the delete failure")
+	}
+
+	// The message was sent but couldn't be deleted so it will be redriven again once it's visible
+	assertBodies(t, s, dest, "one")
+	assertBodies(t, s, src, "one")
+}
@@ -0,0 +1,174 @@
+package sqsdr
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+var (
+	// DefaultRetryPolicy is used by Poller and SQSSink when they don't have a RetryPolicy of their own
+	DefaultRetryPolicy = &RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      0.5,
+	}
+
+	// retryableCodes are the batch entry error codes that are worth retrying even though SQS may blame the sender
+	retryableCodes = map[string]bool{
+		"RequestThrottled":        true,
+		"KmsThrottled":            true,
+		"KMS.ThrottlingException": true,
+		"ThrottlingException":     true,
+		"ServiceUnavailable":      true,
+		"InternalError":           true,
+		"InternalFailure":         true,
+	}
+
+	jitterRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandMu sync.Mutex
+)
+
+// RetryPolicy retries the entries of a batch call to SQS that failed with exponential backoff and jitter. The
+// SDK already retries requests that fail outright so only the entries a batch response lists as Failed are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times an entry is tried, including the first. Values less than 1 are treated as 1.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry. It doubles with every retry after that up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Jitter is the fraction of each delay, between 0 and 1, that is picked at random so that workers retrying at
+	// the same time spread out
+	Jitter float64
+
+	// Retryable decides whether a failed entry is worth retrying. Defaults to RetryableEntry.
+	Retryable func(*sqs.BatchResultErrorEntry) bool
+}
+
+// RetryableEntry retries entries that SQS didn't blame on the sender and entries that were throttled, like
+// RequestThrottled or KmsThrottled
+func RetryableEntry(entry *sqs.BatchResultErrorEntry) bool {
+	if retryableCodes[aws.StringValue(entry.Code)] {
+		return true
+	}
+
+	return !aws.BoolValue(entry.SenderFault)
+}
+
+// retryBatch calls batch with the Ids of every entry and then with the Ids of the retryable entries that failed
+// until they succeed or run out of attempts. It returns the entries that never succeeded. If batch returns an
+// error that error is returned along with the entries that were still to be tried.
+func (r *RetryPolicy) retryBatch(ctx context.Context, ids []string, batch func(ids []string) ([]*sqs.BatchResultErrorEntry, error)) ([]*sqs.BatchResultErrorEntry, error) {
+	var failed []*sqs.BatchResultErrorEntry
+
+	for attempt := 1; ; attempt++ {
+		batchFailed, err := batch(ids)
+		if err != nil {
+			return append(failed, pendingEntries(ids, err)...), err
+		}
+
+		var retry []string
+		for _, entry := range batchFailed {
+			if attempt < r.MaxAttempts && r.retryable(entry) {
+				retry = append(retry, aws.StringValue(entry.Id))
+				continue
+			}
+
+			failed = append(failed, entry)
+		}
+
+		if len(retry) == 0 {
+			return failed, nil
+		}
+
+		delay := r.delay(attempt)
+		log.Printf("retrying %v failed entries in %v. attempt %v of %v\n", len(retry), delay, attempt+1, r.MaxAttempts)
+
+		err = sleep(ctx, delay)
+		if err != nil {
+			return append(failed, pendingEntries(retry, err)...), err
+		}
+
+		ids = retry
+	}
+}
+
+func (r *RetryPolicy) retryable(entry *sqs.BatchResultErrorEntry) bool {
+	if r.Retryable == nil {
+		return RetryableEntry(entry)
+	}
+
+	return r.Retryable(entry)
+}
+
+// delay is the backoff before the retry that follows attempt
+func (r *RetryPolicy) delay(attempt int) time.Duration {
+	delay := r.BaseDelay
+	for i := 1; i < attempt && (r.MaxDelay <= 0 || delay < r.MaxDelay); i++ {
+		delay *= 2
+	}
+
+	if r.MaxDelay > 0 && delay > r.MaxDelay {
+		delay = r.MaxDelay
+	}
+
+	jitter := r.Jitter
+	if jitter <= 0 {
+		return delay
+	}
+
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	jitterRandMu.Lock()
+	f := jitterRand.Float64()
+	jitterRandMu.Unlock()
+
+	fixed := float64(delay) * (1 - jitter)
+	return time.Duration(fixed + f*float64(delay)*jitter)
+}
+
+// retryPolicy returns the policy or DefaultRetryPolicy when it is nil
+func retryPolicy(r *RetryPolicy) *RetryPolicy {
+	if r == nil {
+		return DefaultRetryPolicy
+	}
+
+	return r
+}
+
+// pendingEntries fails the entries that couldn't be tried because of err
+func pendingEntries(ids []string, err error) []*sqs.BatchResultErrorEntry {
+	entries := make([]*sqs.BatchResultErrorEntry, len(ids))
+	for i, id := range ids {
+		entries[i] = &sqs.BatchResultErrorEntry{
+			Id:          aws.String(id),
+			Message:     aws.String(err.Error()),
+			SenderFault: aws.Bool(false),
+		}
+	}
+
+	return entries
+}
+
+// sleep waits for d or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
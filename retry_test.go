@@ -0,0 +1,244 @@
+package sqsdr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestRetryableEntry(t *testing.T) {
+	tests := []struct {
+		code        string
+		senderFault bool
+		want        bool
+	}{
+		{code: "RequestThrottled", senderFault: true, want: true},
+		{code: "KmsThrottled", senderFault: true, want: true},
+		{code: "KMS.ThrottlingException", senderFault: true, want: true},
+		{code: "ThrottlingException", senderFault: true, want: true},
+		{code: "ServiceUnavailable", want: true},
+		{code: "InternalError", want: true},
+		{code: "InternalFailure", want: true},
+		{code: "SomethingNew", want: true},
+		{code: "InvalidParameterValue", senderFault: true, want: false},
+		{code: "ReceiptHandleIsInvalid", senderFault: true, want: false},
+		{code: "MessageTooLong", senderFault: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			entry := &sqs.BatchResultErrorEntry{
+				Id:          aws.String("1"),
+				Code:        aws.String(tt.code),
+				SenderFault: aws.Bool(tt.senderFault),
+			}
+
+			if got := RetryableEntry(entry); got != tt.want {
+				t.Errorf("RetryableEntry(%v, sender fault %v) = %v; want %v", tt.code, tt.senderFault, got, tt.want)
+			}
+		})
+	}
+}
+
+func testRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    5 * time.Millisecond,
+	}
+}
+
+func failedEntry(id string, code string, senderFault bool) *sqs.BatchResultErrorEntry {
+	return &sqs.BatchResultErrorEntry{
+		Id:          aws.String(id),
+		Code:        aws.String(code),
+		SenderFault: aws.Bool(senderFault),
+	}
+}
+
+func entryIDs(entries []*sqs.BatchResultErrorEntry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = aws.StringValue(entry.Id)
+	}
+
+	return ids
+}
+
+func TestRetryBatchRetriesUntilSuccess(t *testing.T) {
+	var calls [][]string
+	batch := func(ids []string) ([]*sqs.BatchResultErrorEntry, error) {
+		calls = append(calls, ids)
+		if len(calls) == 1 {
+			return []*sqs.BatchResultErrorEntry{failedEntry("2", "RequestThrottled", true)}, nil
+		}
+
+		return nil, nil
+	}
+
+	failed, err := testRetryPolicy().retryBatch(context.Background(), []string{"1", "2", "3"}, batch)
+	if err != nil {
+		t.Fatalf("retryBatch returned an error: %v", err)
+	}
+
+	if len(failed) != 0 {
+		t.Errorf("retryBatch failed %v; want none", entryIDs(failed))
+	}
+
+	if len(calls) != 2 || len(calls[1]) != 1 || calls[1][0] != "2" {
+		t.Errorf("batch was called with %v; want every entry and then only 2", calls)
+	}
+}
+
+func TestRetryBatchRunsOutOfAttempts(t *testing.T) {
+	calls := 0
+	batch := func(ids []string) ([]*sqs.BatchResultErrorEntry, error) {
+		calls++
+		return []*sqs.BatchResultErrorEntry{failedEntry("1", "InternalError", false)}, nil
+	}
+
+	failed, err := testRetryPolicy().retryBatch(context.Background(), []string{"1"}, batch)
+	if err != nil {
+		t.Fatalf("retryBatch returned an error: %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("batch was called %v times; want 3", calls)
+	}
+
+	if len(failed) != 1 || aws.StringValue(failed[0].Id) != "1" || aws.StringValue(failed[0].Code) != "InternalError" {
+		t.Errorf("retryBatch failed %v; want the last failure of 1", failed)
+	}
+}
+
+func TestRetryBatchFailsSenderFaultsRightAway(t *testing.T) {
+	var calls [][]string
+	batch := func(ids []string) ([]*sqs.BatchResultErrorEntry, error) {
+		calls = append(calls, ids)
+		if len(calls) == 1 {
+			return []*sqs.BatchResultErrorEntry{
+				failedEntry("1", "InvalidParameterValue", true),
+				failedEntry("2", "RequestThrottled", true),
+			}, nil
+		}
+
+		return nil, nil
+	}
+
+	failed, err := testRetryPolicy().retryBatch(context.Background(), []string{"1", "2"}, batch)
+	if err != nil {
+		t.Fatalf("retryBatch returned an error: %v", err)
+	}
+
+	if ids := entryIDs(failed); len(ids) != 1 || ids[0] != "1" {
+		t.Errorf("retryBatch failed %v; want only 1", ids)
+	}
+
+	if len(calls) != 2 || len(calls[1]) != 1 || calls[1][0] != "2" {
+		t.Errorf("batch was called with %v; want only 2 to be retried", calls)
+	}
+}
+
+func TestRetryBatchReturnsBatchErrors(t *testing.T) {
+	batchErr := errors.New("connection reset")
+	batch := func(ids []string) ([]*sqs.BatchResultErrorEntry, error) {
+		return nil, batchErr
+	}
+
+	failed, err := testRetryPolicy().retryBatch(context.Background(), []string{"1", "2"}, batch)
+	if err != batchErr {
+		t.Errorf("retryBatch returned %v; want %v", err, batchErr)
+	}
+
+	if ids := entryIDs(failed); len(ids) != 2 {
+		t.Errorf("retryBatch failed %v; want every entry", ids)
+	}
+}
+
+func TestRetryBatchStopsWhenCancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policy := &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}
+
+	calls := 0
+	batch := func(ids []string) ([]*sqs.BatchResultErrorEntry, error) {
+		calls++
+		cancel()
+		return []*sqs.BatchResultErrorEntry{failedEntry("1", "RequestThrottled", true)}, nil
+	}
+
+	done := make(chan struct{})
+	var failed []*sqs.BatchResultErrorEntry
+	var err error
+	go func() {
+		failed, err = policy.retryBatch(ctx, []string{"1"}, batch)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retryBatch did not return after the context was cancelled")
+	}
+
+	if err != context.Canceled {
+		t.Errorf("retryBatch returned %v; want %v", err, context.Canceled)
+	}
+
+	if calls != 1 {
+		t.Errorf("batch was called %v times; want 1", calls)
+	}
+
+	if ids := entryIDs(failed); len(ids) != 1 || ids[0] != "1" {
+		t.Errorf("retryBatch failed %v; want 1", ids)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 100 * time.Millisecond},
+		{attempt: 2, want: 200 * time.Millisecond},
+		{attempt: 3, want: 400 * time.Millisecond},
+		{attempt: 4, want: 800 * time.Millisecond},
+		{attempt: 5, want: time.Second},
+		{attempt: 50, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%v) = %v; want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+	tests := []struct {
+		jitter float64
+		min    time.Duration
+	}{
+		{jitter: 0.5, min: 200 * time.Millisecond},
+		{jitter: 1, min: 0},
+		{jitter: 2, min: 0},
+	}
+
+	for _, tt := range tests {
+		policy := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: tt.jitter}
+
+		for i := 0; i < 1000; i++ {
+			got := policy.delay(3)
+			if got < tt.min || got > 400*time.Millisecond {
+				t.Fatalf("delay(3) with jitter %v = %v; want between %v and 400ms", tt.jitter, got, tt.min)
+			}
+		}
+	}
+}
@@ -1 +1,169 @@
 package sqsdr
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	// SendFormatRaw sends each line as the body of a message
+	SendFormatRaw = "raw"
+
+	// SendFormatDump reads each line as a MessageOutput written by Dump or Peek and restores the body and
+	// message attributes
+	SendFormatDump = "dump"
+
+	// maxLineSize leaves room for a 256 KB message body once it has been escaped and wrapped in a MessageOutput
+	maxLineSize = 1024 * 1024
+)
+
+// Send reads newline delimited messages from In and sends them to the destination queue in batches of 10.
+// Sending a file written by Dump with the SendFormatDump format puts the messages back exactly as they were.
+type Send struct {
+	DestClient   sqsiface.SQSAPI
+	DestQueueURL string
+	In           io.Reader
+
+	// Format is either SendFormatRaw or SendFormatDump. Defaults to SendFormatRaw.
+	Format string
+}
+
+// Send is the entry point into the send strategy. Cancelling ctx stops before the next batch is sent.
+func (s *Send) Send(ctx context.Context) error {
+	format := s.Format
+	if format == "" {
+		format = SendFormatRaw
+	}
+
+	if format != SendFormatRaw && format != SendFormatDump {
+		return fmt.Errorf("unknown send format '%v'. expected one of: %v, %v", format, SendFormatRaw, SendFormatDump)
+	}
+
+	sink := &SQSSink{QueueURL: s.DestQueueURL, Client: s.DestClient}
+
+	scanner := bufio.NewScanner(s.In)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+
+	// Every run gets its own prefix so that sending the same file twice within the 5 minute deduplication interval
+	// of a FIFO queue doesn't drop the second run
+	runID := newRunID()
+
+	batch := make([]*sqs.Message, 0, maxBatchEntries)
+	numSent := 0
+	lineNum := 0
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := sink.Sink(ctx, batch)
+		if err != nil {
+			log.Println("encountered error while sending messages to SQS")
+			return err
+		}
+
+		numSent += len(batch)
+		log.Printf("sent %v messages\n", numSent)
+
+		batch = make([]*sqs.Message, 0, maxBatchEntries)
+		return nil
+	}
+
+	for scanner.Scan() {
+		lineNum++
+
+		msg, err := parseSendLine(format, scanner.Text(), lineNum)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := msg.Attributes[messageDeduplicationIDAttribute]; !ok {
+			if msg.Attributes == nil {
+				msg.Attributes = make(map[string]*string, 1)
+			}
+			msg.Attributes[messageDeduplicationIDAttribute] = aws.String(fmt.Sprintf("%v-%v", runID, lineNum))
+		}
+
+		batch = append(batch, msg)
+		if len(batch) == maxBatchEntries {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// parseSendLine turns a line of input into a message. The line number is used as the MessageId so that every
+// entry in a batch has a unique ID. The MessageGroupId and MessageDeduplicationId of a dumped FIFO message are
+// restored so that SQSSink sends it to its original group.
+func parseSendLine(format string, line string, lineNum int) (*sqs.Message, error) {
+	id := aws.String(strconv.Itoa(lineNum))
+
+	if format == SendFormatRaw {
+		return &sqs.Message{MessageId: id, Body: aws.String(line)}, nil
+	}
+
+	var out MessageOutput
+	err := json.Unmarshal([]byte(line), &out)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse line %v as a dumped message: %v", lineNum, err)
+	}
+
+	if out.Body == nil {
+		return nil, fmt.Errorf("dumped message on line %v does not have a Body", lineNum)
+	}
+
+	msg := &sqs.Message{
+		MessageId:         id,
+		Body:              out.Body,
+		MessageAttributes: out.MessageAttributes,
+	}
+
+	for _, name := range []string{messageGroupIDAttribute, messageDeduplicationIDAttribute} {
+		v, ok := out.Attributes[name]
+		if !ok || v == nil {
+			continue
+		}
+
+		if msg.Attributes == nil {
+			msg.Attributes = make(map[string]*string, 2)
+		}
+		msg.Attributes[name] = v
+	}
+
+	return msg, nil
+}
+
+// newRunID returns a random ID for a single run of Send
+func newRunID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(b)
+}
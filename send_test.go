@@ -0,0 +1,155 @@
+package sqsdr
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+func TestSendRaw(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	dest := s.MustCreateQueue("orders")
+
+	lines := make([]string, 25)
+	for i := range lines {
+		lines[i] = "message " + strings.Repeat("x", i)
+	}
+
+	send := &Send{DestClient: s, DestQueueURL: dest, In: strings.NewReader(strings.Join(lines, "\n") + "\n")}
+	err := send.Send(context.Background())
+	if err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	assertBodies(t, s, dest, lines...)
+}
+
+func TestSendDump(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	dest := s.MustCreateQueue("orders")
+
+	in := `{"Body":"{\"id\":1}","MessageAttributes":{"trace":{"DataType":"String","StringValue":"abc"}},"MessageId":"old-1"}` + "\n"
+	send := &Send{DestClient: s, DestQueueURL: dest, In: strings.NewReader(in), Format: SendFormatDump}
+	err := send.Send(context.Background())
+	if err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	msgs := s.Messages(dest)
+	if len(msgs) != 1 || aws.StringValue(msgs[0].Body) != `{"id":1}` {
+		t.Fatalf("destination has %v; want the dumped body", queueBodies(s, dest))
+	}
+
+	if got := aws.StringValue(msgs[0].MessageAttributes["trace"].StringValue); got != "abc" {
+		t.Errorf("trace attribute = %q; want abc", got)
+	}
+}
+
+func TestSendRejectsBadInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		in     string
+	}{
+		{name: "unknown format", format: "csv", in: "one\n"},
+		{name: "invalid json", format: SendFormatDump, in: "not json\n"},
+		{name: "missing body", format: SendFormatDump, in: `{"MessageId":"1"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := sqsdrtest.NewSQS()
+			dest := s.MustCreateQueue("orders")
+
+			send := &Send{DestClient: s, DestQueueURL: dest, In: strings.NewReader(tt.in), Format: tt.format}
+			err := send.Send(context.Background())
+			if err == nil {
+				t.Fatal("Send did not return an error")
+			}
+
+			assertBodies(t, s, dest)
+		})
+	}
+}
+
+func TestSendFIFO(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	dest := s.MustCreateQueue("orders.fifo")
+
+	// Sending the same file twice within the deduplication interval sends it twice
+	for i := 0; i < 2; i++ {
+		send := &Send{DestClient: s, DestQueueURL: dest, In: strings.NewReader("one\ntwo\n")}
+		err := send.Send(context.Background())
+		if err != nil {
+			t.Fatalf("Send returned an error: %v", err)
+		}
+	}
+
+	if got := queueBodies(s, dest); strings.Join(got, ",") != "one,two,one,two" {
+		t.Errorf("destination has %v; want both sends", got)
+	}
+}
+
+func TestSendDumpRestoresFIFOGroups(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq.fifo")
+	dest := s.MustCreateQueue("orders.fifo")
+
+	sent := []struct{ group, dedup, body string }{{"a", "a-1", "retry"}, {"b", "b-1", "retry"}, {"a", "a-2", "a2"}, {"b", "b-2", "b2"}}
+	for _, m := range sent {
+		_, err := s.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:               aws.String(src),
+			MessageBody:            aws.String(m.body),
+			MessageGroupId:         aws.String(m.group),
+			MessageDeduplicationId: aws.String(m.dedup),
+		})
+		if err != nil {
+			t.Fatalf("SendMessage returned an error: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	d := &Dump{SourceClient: s, SourceQueueURL: src, Out: &out}
+	err := d.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("Dump returned an error: %v", err)
+	}
+
+	send := &Send{DestClient: s, DestQueueURL: dest, In: &out, Format: SendFormatDump}
+	err = send.Send(context.Background())
+	if err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	groups := make(map[string][]string)
+	for _, msg := range s.Messages(dest) {
+		group := aws.StringValue(msg.Attributes["MessageGroupId"])
+		dedup := aws.StringValue(msg.Attributes["MessageDeduplicationId"])
+		groups[group] = append(groups[group], dedup+"="+aws.StringValue(msg.Body))
+	}
+
+	want := map[string][]string{"a": {"a-1=retry", "a-2=a2"}, "b": {"b-1=retry", "b-2=b2"}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("destination groups = %v; want %v", groups, want)
+	}
+}
+
+func TestSendPartialFailure(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	dest := s.MustCreateQueue("orders")
+	s.FailSendEntry = failBodies("bad")
+
+	send := &Send{DestClient: s, DestQueueURL: dest, In: strings.NewReader("one\nbad\nthree\n")}
+	err := send.Send(context.Background())
+	if err == nil {
+		t.Fatal("Send did not return the failed entry")
+	}
+
+	assertBodies(t, s, dest, "one", "three")
+}
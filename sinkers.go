@@ -3,16 +3,30 @@ package sqsdr
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
+const (
+	// defaultMessageGroupID is used when sending messages from a standard queue to a FIFO queue
+	defaultMessageGroupID = "sqsdr"
+
+	// maxBatchEntries is the most entries SQS accepts in a single batch request
+	maxBatchEntries = 10
+
+	// maxPayloadSize is the largest message, and the largest batch of messages, that SQS accepts
+	maxPayloadSize = 256 * 1024
+
+	// errCodeMessageTooLong fails entries that are never sent because they're over maxPayloadSize
+	errCodeMessageTooLong = "MessageTooLong"
+)
+
 // Sinker is an interface that accepts an array of SQS messages and puts them
 // somewhere. Where "somewhere" could be into another SQS queue, a file on disk,
 // or whatever.
@@ -20,6 +34,17 @@ type Sinker interface {
 	Sink(context.Context, []*sqs.Message) error
 }
 
+// PartialSinkError is returned by a Sinker that sunk some of the messages it was given but not others. Pipeline
+// lets the rest of the batch be deleted and leaves the Failed messages in the source queue.
+type PartialSinkError struct {
+	Failed []*sqs.Message
+	Err    error
+}
+
+func (p *PartialSinkError) Error() string {
+	return fmt.Sprintf("failed to sink %v messages: %v", len(p.Failed), p.Err)
+}
+
 // NoOpSink drops the messages on the floor. Use it only as a signal to other developers
 // that your other sink is doing all of the work.
 type NoOpSink struct{}
@@ -29,24 +54,119 @@ func (n NoOpSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
 	return nil
 }
 
-// SQSSink pass all messages to the QueueURL with the provided SQS Client
+// SQSSink pass all messages to the QueueURL with the provided SQS Client.
+//
+// If QueueURL is a FIFO queue the MessageGroupId and MessageDeduplicationId are carried over from the
+// system attributes of the received message. Messages from a standard queue are put in a single message
+// group and deduplicated by their MessageId.
 type SQSSink struct {
 	QueueURL string
 	Client   sqsiface.SQSAPI
+
+	// RegenerateDeduplicationID uses the MessageId of the received message as the deduplication ID
+	// instead of the original one. Use it when sending messages back to a FIFO queue they recently came from.
+	RegenerateDeduplicationID bool
+
+	// RetryPolicy optionally changes how messages that failed to send are retried. Defaults to DefaultRetryPolicy.
+	RetryPolicy *RetryPolicy
 }
 
-// Sink performs a BatchSend with the passed in messages
+// Sink performs a BatchSend with the passed in messages. Message attributes are sent unchanged so make sure
+// the Poller that received the messages asked for them. Messages are split into as many batches as it takes to
+// stay under 10 entries and 256 KB per batch. Entries that SQS fails are retried with the RetryPolicy and the ones
+// that never make it, along with any message too large for SQS, are returned in a PartialSinkError.
 func (s *SQSSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
-	entries := make([]*sqs.SendMessageBatchRequestEntry, len(msgs))
-	for i, msg := range msgs {
+	fifo := isFIFOQueue(s.QueueURL)
+
+	entries := make(map[string]*sqs.SendMessageBatchRequestEntry, len(msgs))
+	var (
+		failed    []*sqs.BatchResultErrorEntry
+		batches   [][]string
+		batch     []string
+		batchSize int
+	)
+
+	for _, msg := range msgs {
 		entry := &sqs.SendMessageBatchRequestEntry{
 			Id:                msg.MessageId,
 			MessageAttributes: msg.MessageAttributes,
 			MessageBody:       msg.Body,
 		}
-		entries[i] = entry
+
+		if fifo {
+			entry.MessageGroupId = s.messageGroupID(msg)
+			entry.MessageDeduplicationId = s.messageDeduplicationID(msg)
+		}
+
+		id := aws.StringValue(msg.MessageId)
+		size := entrySize(entry)
+		if size > maxPayloadSize {
+			log.Printf("message %v is %v bytes which is over the SQS limit of %v bytes\n", id, size, maxPayloadSize)
+			failed = append(failed, &sqs.BatchResultErrorEntry{
+				Id:          msg.MessageId,
+				Code:        aws.String(errCodeMessageTooLong),
+				Message:     aws.String(fmt.Sprintf("message is %v bytes with its attributes which is over the SQS limit of %v bytes", size, maxPayloadSize)),
+				SenderFault: aws.Bool(true),
+			})
+			continue
+		}
+
+		if len(batch) == maxBatchEntries || batchSize+size > maxPayloadSize {
+			batches = append(batches, batch)
+			batch = nil
+			batchSize = 0
+		}
+
+		entries[id] = entry
+		batch = append(batch, id)
+		batchSize += size
 	}
 
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	var err error
+	for i, ids := range batches {
+		var batchFailed []*sqs.BatchResultErrorEntry
+		batchFailed, err = retryPolicy(s.RetryPolicy).retryBatch(ctx, ids, func(ids []string) ([]*sqs.BatchResultErrorEntry, error) {
+			batch := make([]*sqs.SendMessageBatchRequestEntry, len(ids))
+			for i, id := range ids {
+				batch[i] = entries[id]
+			}
+
+			return s.sendEntries(ctx, batch)
+		})
+		failed = append(failed, batchFailed...)
+
+		if err != nil {
+			for _, ids := range batches[i+1:] {
+				failed = append(failed, pendingEntries(ids, err)...)
+			}
+
+			break
+		}
+	}
+
+	if len(failed) == len(msgs) && err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		if err == nil {
+			err = compileFailedErrors("failed to batch send messages", failed)
+		}
+
+		return &PartialSinkError{
+			Failed: failedMessages(msgs, failed),
+			Err:    err,
+		}
+	}
+
+	return nil
+}
+
+func (s *SQSSink) sendEntries(ctx context.Context, entries []*sqs.SendMessageBatchRequestEntry) ([]*sqs.BatchResultErrorEntry, error) {
 	resp, err := s.Client.SendMessageBatchWithContext(
 		ctx,
 		&sqs.SendMessageBatchInput{
@@ -55,65 +175,120 @@ func (s *SQSSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
 		},
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if len(resp.Failed) > 0 {
-		var errBuffer bytes.Buffer
-		errBuffer.WriteString("The following error messages were received:\n\n")
+	return resp.Failed, nil
+}
+
+// entrySize is the size SQS counts against its limits: the body plus the name, type, and value of each message
+// attribute
+func entrySize(entry *sqs.SendMessageBatchRequestEntry) int {
+	size := len(aws.StringValue(entry.MessageBody))
+	for name, attr := range entry.MessageAttributes {
+		size += len(name) + len(aws.StringValue(attr.DataType)) + len(aws.StringValue(attr.StringValue)) + len(attr.BinaryValue)
+	}
+
+	return size
+}
+
+// failedMessages returns the messages of the failed batch entries. Entries are sent with the MessageId as their Id.
+func failedMessages(msgs []*sqs.Message, failed []*sqs.BatchResultErrorEntry) []*sqs.Message {
+	byID := make(map[string]*sqs.Message, len(msgs))
+	for _, msg := range msgs {
+		byID[aws.StringValue(msg.MessageId)] = msg
+	}
 
-		for _, f := range resp.Failed {
-			errBuffer.WriteString(
-				fmt.Sprintf("%v\n\n", *f.Message),
-			)
+	out := make([]*sqs.Message, 0, len(failed))
+	for _, f := range failed {
+		if msg, ok := byID[aws.StringValue(f.Id)]; ok {
+			out = append(out, msg)
 		}
+	}
+
+	return out
+}
 
-		return fmt.Errorf("failed to batch send messages: %v", errBuffer.String())
+// messageGroupID returns the group of the received message or a default group if it came from a standard queue
+func (s *SQSSink) messageGroupID(msg *sqs.Message) *string {
+	if isFIFOMessage(msg) {
+		return msg.Attributes[messageGroupIDAttribute]
 	}
 
-	return nil
+	return aws.String(defaultMessageGroupID)
+}
+
+// messageDeduplicationID returns the deduplication ID of the received message falling back to the MessageId
+func (s *SQSSink) messageDeduplicationID(msg *sqs.Message) *string {
+	dedupID, ok := msg.Attributes[messageDeduplicationIDAttribute]
+	if !ok || s.RegenerateDeduplicationID {
+		return msg.MessageId
+	}
+
+	return dedupID
 }
 
 // MessageOutput is a simplified version of the SQS Message that's appropriate to write to disk or
 // STDOUT.
-//
-// TODO: will have to handle the case when putting messages from STDIN to queue
-//       where the message body is JSON.
 type MessageOutput struct {
 	Body              *string                               `json:",omitempty"`
+	OriginalBody      *string                               `json:",omitempty"`
 	MessageAttributes map[string]*sqs.MessageAttributeValue `json:",omitempty"`
 	MessageId         *string
-	ReceiptHandle     *string
+	ReceiptHandle     *string `json:",omitempty"`
+
+	// Attributes are the system attributes a Formatter was asked to include
+	Attributes map[string]*string `json:",omitempty"`
 }
 
 // WriterSink will write SQS Message in the MessageOutput format to the Writer with the delimiter
-// as a separator
+// as a separator, or with the Formatter when it is set. It is safe to use from multiple Poller workers.
 type WriterSink struct {
 	Writer      io.Writer
 	Passthrough Sinker
+
+	// Formatter optionally changes how messages are written. Defaults to an NDJSONFormatter.
+	Formatter Formatter
+
+	// Transformer optionally previews a transformation. The transformed body is written as the Body and the
+	// original body as the OriginalBody. Passthrough always gets the original messages.
+	Transformer Transformer
+
+	mu          sync.Mutex
+	wroteHeader bool
 }
 
 // Sink writes converts the SQS Message to a MessageOutput and writes the message
 // the Writer.
 func (w *WriterSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
 	errors := make([]error, 0)
-	encoder := json.NewEncoder(w.Writer)
 
-	for _, msg := range msgs {
-		msgOut := MessageOutput{
-			Body:              msg.Body,
-			MessageAttributes: msg.MessageAttributes,
-			MessageId:         msg.MessageId,
-			ReceiptHandle:     msg.ReceiptHandle,
+	formatter := w.Formatter
+	if formatter == nil {
+		formatter = &NDJSONFormatter{}
+	}
+
+	// Keep batches from different workers from interleaving
+	w.mu.Lock()
+	if !w.wroteHeader {
+		err := formatter.Header(w.Writer)
+		if err != nil {
+			w.mu.Unlock()
+			return fmt.Errorf("could not write header: %v", err)
 		}
 
-		err := encoder.Encode(msgOut)
+		w.wroteHeader = true
+	}
+
+	for _, msg := range msgs {
+		err := formatter.Format(w.Writer, newMessageOutput(msg, w.Transformer))
 		if err != nil {
 			log.Println("an error occurred while dumping SQS message to JSON:", err)
 			errors = append(errors, err)
 			continue
 		}
 	}
+	w.mu.Unlock()
 
 	if len(errors) > 0 {
 		var buffer bytes.Buffer
@@ -127,3 +302,30 @@ func (w *WriterSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
 
 	return w.Passthrough.Sink(ctx, msgs)
 }
+
+// newMessageOutput converts a message to a MessageOutput. If the Transformer is set the transformed body is the Body
+// and the original body is the OriginalBody. Messages that fail to transform are written as they are since a
+// redrive would leave them in the source queue.
+func newMessageOutput(msg *sqs.Message, t Transformer) MessageOutput {
+	msgOut := MessageOutput{
+		Body:              msg.Body,
+		MessageAttributes: msg.MessageAttributes,
+		MessageId:         msg.MessageId,
+		ReceiptHandle:     msg.ReceiptHandle,
+		Attributes:        msg.Attributes,
+	}
+
+	if t == nil {
+		return msgOut
+	}
+
+	transformed, err := t.Transform(msg)
+	if err != nil {
+		log.Println("could not transform SQS message:", err)
+		return msgOut
+	}
+
+	msgOut.Body = transformed.Body
+	msgOut.OriginalBody = msg.Body
+	return msgOut
+}
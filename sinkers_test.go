@@ -0,0 +1,216 @@
+package sqsdr
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+// batchRecorder records the number of entries and the size of every batch sent through it
+type batchRecorder struct {
+	*sqsdrtest.SQS
+
+	counts []int
+	sizes  []int
+}
+
+func (b *batchRecorder) SendMessageBatchWithContext(ctx aws.Context, req *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	size := 0
+	for _, entry := range req.Entries {
+		size += entrySize(entry)
+	}
+
+	b.counts = append(b.counts, len(req.Entries))
+	b.sizes = append(b.sizes, size)
+
+	return b.SQS.SendMessageBatchWithContext(ctx, req, opts...)
+}
+
+// sizedMessages returns n messages with bodies of size bytes. Their MessageIds start at the first ID.
+func sizedMessages(first int, n int, size int) []*sqs.Message {
+	msgs := make([]*sqs.Message, n)
+	for i := range msgs {
+		msgs[i] = &sqs.Message{
+			MessageId: aws.String(strconv.Itoa(first + i)),
+			Body:      aws.String(strings.Repeat("x", size)),
+		}
+	}
+
+	return msgs
+}
+
+func TestSQSSinkSplitsBatches(t *testing.T) {
+	const kb = 1024
+
+	tests := []struct {
+		name       string
+		msgs       []*sqs.Message
+		wantCounts []int
+	}{
+		{
+			name:       "under the entry limit",
+			msgs:       sizedMessages(0, 7, 10),
+			wantCounts: []int{7},
+		},
+		{
+			name:       "split by entries",
+			msgs:       sizedMessages(0, 25, 10),
+			wantCounts: []int{10, 10, 5},
+		},
+		{
+			name:       "split by size",
+			msgs:       sizedMessages(0, 5, 100*kb),
+			wantCounts: []int{2, 2, 1},
+		},
+		{
+			name:       "largest message SQS accepts",
+			msgs:       append(sizedMessages(0, 1, maxPayloadSize), sizedMessages(1, 1, 1)...),
+			wantCounts: []int{1, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := sqsdrtest.NewSQS()
+			dest := s.MustCreateQueue("orders")
+			client := &batchRecorder{SQS: s}
+
+			sink := &SQSSink{QueueURL: dest, Client: client}
+			err := sink.Sink(context.Background(), test.msgs)
+			if err != nil {
+				t.Fatalf("Sink returned an error: %v", err)
+			}
+
+			if !reflect.DeepEqual(client.counts, test.wantCounts) {
+				t.Errorf("sent batches of %v entries; want %v", client.counts, test.wantCounts)
+			}
+
+			for i, size := range client.sizes {
+				if size > maxPayloadSize {
+					t.Errorf("batch %v is %v bytes which is over %v", i, size, maxPayloadSize)
+				}
+			}
+
+			if got := len(s.Messages(dest)); got != len(test.msgs) {
+				t.Errorf("destination has %v messages; want %v", got, len(test.msgs))
+			}
+		})
+	}
+}
+
+func TestSQSSinkFailsMessagesThatAreTooLong(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	dest := s.MustCreateQueue("orders")
+	client := &batchRecorder{SQS: s}
+
+	msgs := sizedMessages(0, 25, 100*1024)
+	tooLong := sizedMessages(25, 1, 300*1024)[0]
+	msgs = append(msgs[:10], append([]*sqs.Message{tooLong}, msgs[10:]...)...)
+
+	sink := &SQSSink{QueueURL: dest, Client: client}
+	err := sink.Sink(context.Background(), msgs)
+	partial, ok := err.(*PartialSinkError)
+	if !ok {
+		t.Fatalf("Sink returned %v; want a PartialSinkError", err)
+	}
+
+	if len(partial.Failed) != 1 || partial.Failed[0] != tooLong {
+		t.Errorf("failed %v messages; want only the one that is too long", len(partial.Failed))
+	}
+
+	if !strings.Contains(partial.Err.Error(), aws.StringValue(tooLong.MessageId)) {
+		t.Errorf("error %q does not name message %v", partial.Err, aws.StringValue(tooLong.MessageId))
+	}
+
+	if got := len(s.Messages(dest)); got != 25 {
+		t.Errorf("destination has %v messages; want 25", got)
+	}
+
+	// Two 100 KB messages fit in a batch and the one that is too long is never sent
+	sent := 0
+	for _, n := range client.counts {
+		sent += n
+	}
+	if sent != 25 || len(client.counts) != 13 {
+		t.Errorf("sent %v entries in %v batches; want 25 in 13", sent, len(client.counts))
+	}
+}
+
+func TestSQSSinkCountsAttributesAgainstTheLimit(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	dest := s.MustCreateQueue("orders")
+
+	// The body fits on its own but not with its attribute
+	msg := sizedMessages(0, 1, maxPayloadSize-10)[0]
+	msg.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+		"Tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+	}
+
+	sink := &SQSSink{QueueURL: dest, Client: s}
+	err := sink.Sink(context.Background(), []*sqs.Message{msg})
+	partial, ok := err.(*PartialSinkError)
+	if !ok || len(partial.Failed) != 1 || !strings.Contains(partial.Err.Error(), "over the SQS limit") {
+		t.Fatalf("Sink returned %v; want the message to be too long", err)
+	}
+
+	assertBodies(t, s, dest)
+}
+
+func TestEntrySize(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		attributes map[string]*sqs.MessageAttributeValue
+		want       int
+	}{
+		{
+			name: "body only",
+			body: "hello",
+			want: 5,
+		},
+		{
+			name: "string attribute",
+			body: "hello",
+			attributes: map[string]*sqs.MessageAttributeValue{
+				"Tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+			},
+			want: 5 + len("Tenant") + len("String") + len("acme"),
+		},
+		{
+			name: "number attribute with a custom type",
+			body: "hello",
+			attributes: map[string]*sqs.MessageAttributeValue{
+				"Price": {DataType: aws.String("Number.USD"), StringValue: aws.String("9.99")},
+			},
+			want: 5 + len("Price") + len("Number.USD") + len("9.99"),
+		},
+		{
+			name: "binary attribute",
+			body: "",
+			attributes: map[string]*sqs.MessageAttributeValue{
+				"Blob": {DataType: aws.String("Binary"), BinaryValue: []byte{1, 2, 3}},
+			},
+			want: len("Blob") + len("Binary") + 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := &sqs.SendMessageBatchRequestEntry{
+				MessageBody:       aws.String(test.body),
+				MessageAttributes: test.attributes,
+			}
+
+			if got := entrySize(entry); got != test.want {
+				t.Errorf("entrySize = %v; want %v", got, test.want)
+			}
+		})
+	}
+}
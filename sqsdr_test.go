@@ -0,0 +1,90 @@
+package sqsdr
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+func TestMain(m *testing.M) {
+	// The strategies log every batch. Run the tests with -v to see them.
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	os.Exit(m.Run())
+}
+
+// sendBodies sends a message for each body to the queue
+func sendBodies(s *sqsdrtest.SQS, queueURL string, bodies ...string) {
+	for _, body := range bodies {
+		s.MustSendMessage(queueURL, body)
+	}
+}
+
+// queueBodies returns the bodies of every message in the queue in the order they were sent
+func queueBodies(s *sqsdrtest.SQS, queueURL string) []string {
+	msgs := s.Messages(queueURL)
+	bodies := make([]string, len(msgs))
+	for i, msg := range msgs {
+		bodies[i] = aws.StringValue(msg.Body)
+	}
+
+	return bodies
+}
+
+// assertBodies fails the test unless the queue holds exactly the bodies, in any order
+func assertBodies(t *testing.T, s *sqsdrtest.SQS, queueURL string, want ...string) {
+	t.Helper()
+
+	got := queueBodies(s, queueURL)
+	sort.Strings(got)
+
+	sorted := append([]string{}, want...)
+	sort.Strings(sorted)
+
+	if len(got) == 0 && len(sorted) == 0 {
+		return
+	}
+
+	if !reflect.DeepEqual(got, sorted) {
+		t.Errorf("%v has %q; want %q", queueNameFromURL(queueURL), got, sorted)
+	}
+}
+
+// assertNoFallthroughQueues fails the test if a strategy left a fallthrough queue behind
+func assertNoFallthroughQueues(t *testing.T, s *sqsdrtest.SQS) {
+	t.Helper()
+
+	for _, queueURL := range s.QueueURLs() {
+		if isFallthroughQueue(queueURL) {
+			t.Errorf("fallthrough queue %v was left behind", queueURL)
+		}
+	}
+}
+
+// failBodies fails every send of a message with one of the bodies with a sender fault that isn't retried
+func failBodies(bodies ...string) func(string, *sqs.SendMessageBatchRequestEntry) *sqs.BatchResultErrorEntry {
+	return func(queueURL string, entry *sqs.SendMessageBatchRequestEntry) *sqs.BatchResultErrorEntry {
+		for _, body := range bodies {
+			if aws.StringValue(entry.MessageBody) == body {
+				return &sqs.BatchResultErrorEntry{
+					Code:        aws.String("InvalidParameterValue"),
+					Message:     aws.String("rejected by the test"),
+					SenderFault: aws.Bool(true),
+				}
+			}
+		}
+
+		return nil
+	}
+}
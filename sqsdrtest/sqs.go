@@ -0,0 +1,1062 @@
+// Package sqsdrtest provides an in-memory SQS for testing code built on sqsdr without AWS.
+//
+// SQS implements sqsiface.SQSAPI and can be passed anywhere sqsdr takes a client. It models queues, receives with
+// visibility timeouts, batch sends and deletes, FIFO message groups and deduplication, and partial batch failures.
+// Calling an operation that isn't modeled panics.
+package sqsdrtest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	defaultRegion    = "us-east-1"
+	defaultAccountID = "123456789012"
+	senderID         = "AIDASQSDRTEST"
+
+	defaultVisibilityTimeout = 30 * time.Second
+	deduplicationInterval    = 5 * time.Minute
+
+	maxBatchEntries      = 10
+	maxMessageSize       = 256 * 1024
+	maxListQueuesResults = 1000
+	fifoSuffix           = ".fifo"
+)
+
+// NewSQS returns an empty in-memory SQS in us-east-1 with the account ID 123456789012
+func NewSQS() *SQS {
+	return &SQS{
+		Region:    defaultRegion,
+		AccountID: defaultAccountID,
+		queues:    make(map[string]*queue),
+	}
+}
+
+// SQS is an in-memory implementation of sqsiface.SQSAPI. It is safe for concurrent use.
+//
+// Long polling is not modeled. ReceiveMessage returns right away even when WaitTimeSeconds is set, so a Poller
+// finishes as soon as it has seen MaxEmptyReceives empty responses in a row. Use Advance to move the clock forward
+// instead of sleeping while testing visibility timeouts.
+type SQS struct {
+	// Embedded so that SQS satisfies the interface. Operations that aren't modeled call through to the nil
+	// interface and panic.
+	sqsiface.SQSAPI
+
+	Region    string
+	AccountID string
+
+	// FailSendEntry optionally fails individual entries of a SendMessageBatch. Returning nil lets the entry through.
+	FailSendEntry func(queueURL string, entry *sqs.SendMessageBatchRequestEntry) *sqs.BatchResultErrorEntry
+
+	// FailDeleteEntry optionally fails individual entries of a DeleteMessageBatch. Returning nil lets the entry
+	// through.
+	FailDeleteEntry func(queueURL string, entry *sqs.DeleteMessageBatchRequestEntry) *sqs.BatchResultErrorEntry
+
+	mu          sync.Mutex
+	queues      map[string]*queue
+	clockOffset time.Duration
+	nextID      int64
+}
+
+type queue struct {
+	name       string
+	url        string
+	fifo       bool
+	attributes map[string]string
+	tags       map[string]string
+
+	// messages are kept in the order they were sent
+	messages      []*message
+	deduplication map[string]time.Time
+	sequence      int64
+}
+
+type message struct {
+	id                string
+	body              string
+	messageAttributes map[string]*sqs.MessageAttributeValue
+
+	groupID         string
+	deduplicationID string
+	sequenceNumber  string
+
+	sentAt         time.Time
+	firstReceiveAt time.Time
+	receiveCount   int
+	visibleAt      time.Time
+	receiptHandle  string
+}
+
+// Advance moves the clock of the fake forward. Messages whose visibility timeout or delay has passed become
+// visible again.
+func (s *SQS) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clockOffset += d
+}
+
+// MustCreateQueue creates a queue and returns its URL. Names ending in .fifo create FIFO queues with content based
+// deduplication. It panics if the queue can't be created.
+func (s *SQS) MustCreateQueue(name string) string {
+	req := &sqs.CreateQueueInput{QueueName: aws.String(name)}
+	if strings.HasSuffix(name, fifoSuffix) {
+		req.Attributes = map[string]*string{
+			sqs.QueueAttributeNameFifoQueue:                 aws.String("true"),
+			sqs.QueueAttributeNameContentBasedDeduplication: aws.String("true"),
+		}
+	}
+
+	resp, err := s.CreateQueue(req)
+	if err != nil {
+		panic(err)
+	}
+
+	return *resp.QueueUrl
+}
+
+// MustSendMessage sends a message with the body to the queue and returns its MessageId. Messages sent to a FIFO
+// queue are put in the "default" group. It panics if the message can't be sent.
+func (s *SQS) MustSendMessage(queueURL string, body string) string {
+	req := &sqs.SendMessageInput{QueueUrl: aws.String(queueURL), MessageBody: aws.String(body)}
+	if strings.HasSuffix(queueURL, fifoSuffix) {
+		req.MessageGroupId = aws.String("default")
+	}
+
+	resp, err := s.SendMessage(req)
+	if err != nil {
+		panic(err)
+	}
+
+	return *resp.MessageId
+}
+
+// Messages returns a copy of every message in the queue, visible or not, in the order they were sent. It does not
+// count as a receive. It returns nil if the queue doesn't exist.
+func (s *SQS) Messages(queueURL string) []*sqs.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[queueURL]
+	if !ok {
+		return nil
+	}
+
+	msgs := make([]*sqs.Message, len(q.messages))
+	for i, m := range q.messages {
+		msgs[i] = m.toSQS(q, []string{sqs.QueueAttributeNameAll}, []string{sqs.QueueAttributeNameAll})
+	}
+
+	return msgs
+}
+
+// QueueURLs returns the URL of every queue in sorted order
+func (s *SQS) QueueURLs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urls := make([]string, 0, len(s.queues))
+	for u := range s.queues {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	return urls
+}
+
+// CreateQueue creates a queue or returns the URL of an existing queue with the same name
+func (s *SQS) CreateQueue(req *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := aws.StringValue(req.QueueName)
+	if name == "" {
+		return nil, awserr.New("InvalidParameterValue", "QueueName is required", nil)
+	}
+
+	attributes := make(map[string]string, len(req.Attributes))
+	for k, v := range req.Attributes {
+		attributes[k] = aws.StringValue(v)
+	}
+
+	fifo := attributes[sqs.QueueAttributeNameFifoQueue] == "true"
+	if fifo != strings.HasSuffix(name, fifoSuffix) {
+		return nil, awserr.New("InvalidParameterValue", "the name of a FIFO queue must end with the .fifo suffix", nil)
+	}
+
+	queueURL := s.queueURL(name)
+	if q, ok := s.queues[queueURL]; ok {
+		if q.fifo != fifo {
+			return nil, awserr.New(sqs.ErrCodeQueueNameExists, fmt.Sprintf("a queue named %v already exists with different attributes", name), nil)
+		}
+
+		return &sqs.CreateQueueOutput{QueueUrl: aws.String(queueURL)}, nil
+	}
+
+	if _, ok := attributes[sqs.QueueAttributeNameVisibilityTimeout]; !ok {
+		attributes[sqs.QueueAttributeNameVisibilityTimeout] = strconv.Itoa(int(defaultVisibilityTimeout.Seconds()))
+	}
+
+	s.queues[queueURL] = &queue{
+		name:          name,
+		url:           queueURL,
+		fifo:          fifo,
+		attributes:    attributes,
+		tags:          make(map[string]string),
+		deduplication: make(map[string]time.Time),
+	}
+
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(queueURL)}, nil
+}
+
+// CreateQueueWithContext is CreateQueue with a context
+func (s *SQS) CreateQueueWithContext(ctx aws.Context, req *sqs.CreateQueueInput, opts ...request.Option) (*sqs.CreateQueueOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.CreateQueue(req)
+}
+
+// DeleteQueue removes a queue and every message in it
+func (s *SQS) DeleteQueue(req *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(s.queues, q.url)
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+// DeleteQueueWithContext is DeleteQueue with a context
+func (s *SQS) DeleteQueueWithContext(ctx aws.Context, req *sqs.DeleteQueueInput, opts ...request.Option) (*sqs.DeleteQueueOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.DeleteQueue(req)
+}
+
+// GetQueueUrl looks a queue up by name. QueueOwnerAWSAccountId must match AccountID when it is set.
+func (s *SQS) GetQueueUrl(req *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.QueueOwnerAWSAccountId != nil && *req.QueueOwnerAWSAccountId != s.AccountID {
+		return nil, queueDoesNotExist(aws.StringValue(req.QueueName))
+	}
+
+	queueURL := s.queueURL(aws.StringValue(req.QueueName))
+	if _, ok := s.queues[queueURL]; !ok {
+		return nil, queueDoesNotExist(aws.StringValue(req.QueueName))
+	}
+
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(queueURL)}, nil
+}
+
+// GetQueueUrlWithContext is GetQueueUrl with a context
+func (s *SQS) GetQueueUrlWithContext(ctx aws.Context, req *sqs.GetQueueUrlInput, opts ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.GetQueueUrl(req)
+}
+
+// ListQueues returns the URLs of the queues whose names start with QueueNamePrefix. Like SQS it returns at most
+// 1000 of them.
+func (s *SQS) ListQueues(req *sqs.ListQueuesInput) (*sqs.ListQueuesOutput, error) {
+	prefix := aws.StringValue(req.QueueNamePrefix)
+
+	urls := make([]*string, 0)
+	for _, u := range s.QueueURLs() {
+		if len(urls) == maxListQueuesResults {
+			break
+		}
+
+		if strings.HasPrefix(u[strings.LastIndex(u, "/")+1:], prefix) {
+			urls = append(urls, aws.String(u))
+		}
+	}
+
+	return &sqs.ListQueuesOutput{QueueUrls: urls}, nil
+}
+
+// ListQueuesWithContext is ListQueues with a context
+func (s *SQS) ListQueuesWithContext(ctx aws.Context, req *sqs.ListQueuesInput, opts ...request.Option) (*sqs.ListQueuesOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.ListQueues(req)
+}
+
+// TagQueue adds tags to a queue
+func (s *SQS) TagQueue(req *sqs.TagQueueInput) (*sqs.TagQueueOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range req.Tags {
+		q.tags[k] = aws.StringValue(v)
+	}
+
+	return &sqs.TagQueueOutput{}, nil
+}
+
+// TagQueueWithContext is TagQueue with a context
+func (s *SQS) TagQueueWithContext(ctx aws.Context, req *sqs.TagQueueInput, opts ...request.Option) (*sqs.TagQueueOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.TagQueue(req)
+}
+
+// ListQueueTags returns the tags of a queue
+func (s *SQS) ListQueueTags(req *sqs.ListQueueTagsInput) (*sqs.ListQueueTagsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]*string, len(q.tags))
+	for k, v := range q.tags {
+		tags[k] = aws.String(v)
+	}
+
+	return &sqs.ListQueueTagsOutput{Tags: tags}, nil
+}
+
+// ListQueueTagsWithContext is ListQueueTags with a context
+func (s *SQS) ListQueueTagsWithContext(ctx aws.Context, req *sqs.ListQueueTagsInput, opts ...request.Option) (*sqs.ListQueueTagsOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.ListQueueTags(req)
+}
+
+// GetQueueAttributes returns the attributes the queue was created with plus the approximate message counts and
+// the queue ARN
+func (s *SQS) GetQueueAttributes(req *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	visible, notVisible := 0, 0
+	for _, m := range q.messages {
+		if m.visibleAt.After(now) {
+			notVisible++
+		} else {
+			visible++
+		}
+	}
+
+	all := make(map[string]string, len(q.attributes)+3)
+	for k, v := range q.attributes {
+		all[k] = v
+	}
+	all[sqs.QueueAttributeNameApproximateNumberOfMessages] = strconv.Itoa(visible)
+	all[sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible] = strconv.Itoa(notVisible)
+	all[sqs.QueueAttributeNameQueueArn] = fmt.Sprintf("arn:aws:sqs:%v:%v:%v", s.Region, s.AccountID, q.name)
+
+	names := aws.StringValueSlice(req.AttributeNames)
+	attributes := make(map[string]*string)
+	for k, v := range all {
+		if wants(names, k) {
+			attributes[k] = aws.String(v)
+		}
+	}
+
+	return &sqs.GetQueueAttributesOutput{Attributes: attributes}, nil
+}
+
+// GetQueueAttributesWithContext is GetQueueAttributes with a context
+func (s *SQS) GetQueueAttributesWithContext(ctx aws.Context, req *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.GetQueueAttributes(req)
+}
+
+// SendMessage sends a single message
+func (s *SQS) SendMessage(req *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &sqs.SendMessageBatchRequestEntry{
+		Id:                     aws.String("0"),
+		MessageBody:            req.MessageBody,
+		MessageAttributes:      req.MessageAttributes,
+		DelaySeconds:           req.DelaySeconds,
+		MessageGroupId:         req.MessageGroupId,
+		MessageDeduplicationId: req.MessageDeduplicationId,
+	}
+
+	m, err := s.send(q, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqs.SendMessageOutput{
+		MessageId:        aws.String(m.id),
+		MD5OfMessageBody: aws.String(md5Hex(m.body)),
+		SequenceNumber:   nilIfEmpty(m.sequenceNumber),
+	}, nil
+}
+
+// SendMessageWithContext is SendMessage with a context
+func (s *SQS) SendMessageWithContext(ctx aws.Context, req *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.SendMessage(req)
+}
+
+// SendMessageBatch sends up to 10 messages. Entries that FailSendEntry fails, or that SQS would reject on their
+// own, are returned in Failed.
+func (s *SQS) SendMessageBatch(req *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]*string, len(req.Entries))
+	size := 0
+	for i, entry := range req.Entries {
+		ids[i] = entry.Id
+		size += messageSize(entry.MessageBody, entry.MessageAttributes)
+	}
+
+	err = validateBatch(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > maxMessageSize {
+		return nil, awserr.New(
+			sqs.ErrCodeBatchRequestTooLong,
+			fmt.Sprintf("batch requests cannot be longer than %v bytes. you have sent %v bytes", maxMessageSize, size),
+			nil,
+		)
+	}
+
+	resp := &sqs.SendMessageBatchOutput{
+		Successful: make([]*sqs.SendMessageBatchResultEntry, 0, len(req.Entries)),
+		Failed:     make([]*sqs.BatchResultErrorEntry, 0),
+	}
+
+	for _, entry := range req.Entries {
+		if s.FailSendEntry != nil {
+			if failed := s.FailSendEntry(q.url, entry); failed != nil {
+				failed.Id = entry.Id
+				resp.Failed = append(resp.Failed, failed)
+				continue
+			}
+		}
+
+		m, err := s.send(q, entry)
+		if err != nil {
+			resp.Failed = append(resp.Failed, batchError(entry.Id, err))
+			continue
+		}
+
+		resp.Successful = append(resp.Successful, &sqs.SendMessageBatchResultEntry{
+			Id:               entry.Id,
+			MessageId:        aws.String(m.id),
+			MD5OfMessageBody: aws.String(md5Hex(m.body)),
+			SequenceNumber:   nilIfEmpty(m.sequenceNumber),
+		})
+	}
+
+	return resp, nil
+}
+
+// SendMessageBatchWithContext is SendMessageBatch with a context
+func (s *SQS) SendMessageBatchWithContext(ctx aws.Context, req *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.SendMessageBatch(req)
+}
+
+// ReceiveMessage receives up to MaxNumberOfMessages visible messages and hides them for the visibility timeout.
+// FIFO queues only return messages from groups that have nothing in flight, in the order they were sent.
+func (s *SQS) ReceiveMessage(req *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	max := int(aws.Int64Value(req.MaxNumberOfMessages))
+	if max == 0 {
+		max = 1
+	}
+
+	if max < 1 || max > maxBatchEntries {
+		return nil, awserr.New("InvalidParameterValue", "MaxNumberOfMessages must be between 1 and 10", nil)
+	}
+
+	visibilityTimeout := q.visibilityTimeout()
+	if req.VisibilityTimeout != nil {
+		visibilityTimeout = time.Duration(*req.VisibilityTimeout) * time.Second
+	}
+
+	now := s.now()
+
+	// Message groups with a message in flight are locked until it is deleted or becomes visible again
+	locked := make(map[string]bool)
+	if q.fifo {
+		for _, m := range q.messages {
+			if m.visibleAt.After(now) && m.receiveCount > 0 {
+				locked[m.groupID] = true
+			}
+		}
+	}
+
+	attributeNames := aws.StringValueSlice(req.AttributeNames)
+	messageAttributeNames := aws.StringValueSlice(req.MessageAttributeNames)
+
+	msgs := make([]*sqs.Message, 0, max)
+	for _, m := range q.messages {
+		if len(msgs) == max {
+			break
+		}
+
+		if m.visibleAt.After(now) {
+			// A delayed or in flight message holds up the rest of its group
+			if q.fifo {
+				locked[m.groupID] = true
+			}
+			continue
+		}
+
+		if q.fifo && locked[m.groupID] {
+			continue
+		}
+
+		m.receiveCount++
+		if m.firstReceiveAt.IsZero() {
+			m.firstReceiveAt = now
+		}
+		m.visibleAt = now.Add(visibilityTimeout)
+		m.receiptHandle = s.newID("receipt")
+
+		msgs = append(msgs, m.toSQS(q, attributeNames, messageAttributeNames))
+	}
+
+	return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+}
+
+// ReceiveMessageWithContext is ReceiveMessage with a context
+func (s *SQS) ReceiveMessageWithContext(ctx aws.Context, req *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.ReceiveMessage(req)
+}
+
+// DeleteMessage deletes a single message by its latest receipt handle
+func (s *SQS) DeleteMessage(req *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.delete(aws.StringValue(req.ReceiptHandle))
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// DeleteMessageWithContext is DeleteMessage with a context
+func (s *SQS) DeleteMessageWithContext(ctx aws.Context, req *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.DeleteMessage(req)
+}
+
+// DeleteMessageBatch deletes up to 10 messages by their latest receipt handles. Entries that FailDeleteEntry
+// fails, or whose receipt handle is stale, are returned in Failed.
+func (s *SQS) DeleteMessageBatch(req *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]*string, len(req.Entries))
+	for i, entry := range req.Entries {
+		ids[i] = entry.Id
+	}
+
+	err = validateBatch(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &sqs.DeleteMessageBatchOutput{
+		Successful: make([]*sqs.DeleteMessageBatchResultEntry, 0, len(req.Entries)),
+		Failed:     make([]*sqs.BatchResultErrorEntry, 0),
+	}
+
+	for _, entry := range req.Entries {
+		if s.FailDeleteEntry != nil {
+			if failed := s.FailDeleteEntry(q.url, entry); failed != nil {
+				failed.Id = entry.Id
+				resp.Failed = append(resp.Failed, failed)
+				continue
+			}
+		}
+
+		err := q.delete(aws.StringValue(entry.ReceiptHandle))
+		if err != nil {
+			resp.Failed = append(resp.Failed, batchError(entry.Id, err))
+			continue
+		}
+
+		resp.Successful = append(resp.Successful, &sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+
+	return resp, nil
+}
+
+// DeleteMessageBatchWithContext is DeleteMessageBatch with a context
+func (s *SQS) DeleteMessageBatchWithContext(ctx aws.Context, req *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.DeleteMessageBatch(req)
+}
+
+// ChangeMessageVisibility changes the visibility timeout of a single in flight message
+func (s *SQS) ChangeMessageVisibility(req *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.changeVisibility(aws.StringValue(req.ReceiptHandle), aws.Int64Value(req.VisibilityTimeout), s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// ChangeMessageVisibilityWithContext is ChangeMessageVisibility with a context
+func (s *SQS) ChangeMessageVisibilityWithContext(ctx aws.Context, req *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.ChangeMessageVisibility(req)
+}
+
+// ChangeMessageVisibilityBatch changes the visibility timeout of up to 10 in flight messages
+func (s *SQS) ChangeMessageVisibilityBatch(req *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.queue(req.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]*string, len(req.Entries))
+	for i, entry := range req.Entries {
+		ids[i] = entry.Id
+	}
+
+	err = validateBatch(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &sqs.ChangeMessageVisibilityBatchOutput{
+		Successful: make([]*sqs.ChangeMessageVisibilityBatchResultEntry, 0, len(req.Entries)),
+		Failed:     make([]*sqs.BatchResultErrorEntry, 0),
+	}
+
+	now := s.now()
+	for _, entry := range req.Entries {
+		err := q.changeVisibility(aws.StringValue(entry.ReceiptHandle), aws.Int64Value(entry.VisibilityTimeout), now)
+		if err != nil {
+			resp.Failed = append(resp.Failed, batchError(entry.Id, err))
+			continue
+		}
+
+		resp.Successful = append(resp.Successful, &sqs.ChangeMessageVisibilityBatchResultEntry{Id: entry.Id})
+	}
+
+	return resp, nil
+}
+
+// ChangeMessageVisibilityBatchWithContext is ChangeMessageVisibilityBatch with a context
+func (s *SQS) ChangeMessageVisibilityBatchWithContext(ctx aws.Context, req *sqs.ChangeMessageVisibilityBatchInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.ChangeMessageVisibilityBatch(req)
+}
+
+// send validates an entry and adds it to the queue. Callers must hold the lock.
+func (s *SQS) send(q *queue, entry *sqs.SendMessageBatchRequestEntry) (*message, error) {
+	body := aws.StringValue(entry.MessageBody)
+	if body == "" {
+		return nil, awserr.New("MissingParameter", "the request must contain the parameter MessageBody", nil)
+	}
+
+	if size := messageSize(entry.MessageBody, entry.MessageAttributes); size > maxMessageSize {
+		return nil, awserr.New(
+			"InvalidParameterValue",
+			fmt.Sprintf("message must be shorter than %v bytes. it is %v bytes", maxMessageSize, size),
+			nil,
+		)
+	}
+
+	now := s.now()
+	m := &message{
+		id:                s.newID("message"),
+		body:              body,
+		messageAttributes: entry.MessageAttributes,
+		sentAt:            now,
+		visibleAt:         now.Add(time.Duration(aws.Int64Value(entry.DelaySeconds)) * time.Second),
+	}
+
+	if !q.fifo {
+		if entry.MessageGroupId != nil || entry.MessageDeduplicationId != nil {
+			return nil, awserr.New("InvalidParameterValue", "MessageGroupId and MessageDeduplicationId are only valid for FIFO queues", nil)
+		}
+
+		q.messages = append(q.messages, m)
+		return m, nil
+	}
+
+	if entry.MessageGroupId == nil {
+		return nil, awserr.New("MissingParameter", "the request must contain the parameter MessageGroupId", nil)
+	}
+
+	m.groupID = *entry.MessageGroupId
+	m.deduplicationID = aws.StringValue(entry.MessageDeduplicationId)
+	if m.deduplicationID == "" {
+		if q.attributes[sqs.QueueAttributeNameContentBasedDeduplication] != "true" {
+			return nil, awserr.New(
+				"InvalidParameterValue",
+				"the queue should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly",
+				nil,
+			)
+		}
+
+		sum := sha256.Sum256([]byte(body))
+		m.deduplicationID = hex.EncodeToString(sum[:])
+	}
+
+	q.sequence++
+	m.sequenceNumber = fmt.Sprintf("%020d", q.sequence)
+
+	// Duplicates are accepted but never delivered, just like SQS
+	if sentAt, ok := q.deduplication[m.deduplicationID]; ok && now.Sub(sentAt) < deduplicationInterval {
+		return m, nil
+	}
+	q.deduplication[m.deduplicationID] = now
+
+	q.messages = append(q.messages, m)
+	return m, nil
+}
+
+// queue looks up a queue by URL. Callers must hold the lock.
+func (s *SQS) queue(queueURL *string) (*queue, error) {
+	q, ok := s.queues[aws.StringValue(queueURL)]
+	if !ok {
+		return nil, queueDoesNotExist(aws.StringValue(queueURL))
+	}
+
+	return q, nil
+}
+
+func (s *SQS) queueURL(name string) string {
+	return fmt.Sprintf("https://sqs.%v.amazonaws.com/%v/%v", s.Region, s.AccountID, name)
+}
+
+func (s *SQS) now() time.Time {
+	return time.Now().Add(s.clockOffset)
+}
+
+func (s *SQS) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%v-%08d", prefix, s.nextID)
+}
+
+func (q *queue) visibilityTimeout() time.Duration {
+	seconds, err := strconv.Atoi(q.attributes[sqs.QueueAttributeNameVisibilityTimeout])
+	if err != nil {
+		return defaultVisibilityTimeout
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func (q *queue) delete(receiptHandle string) error {
+	for i, m := range q.messages {
+		if m.receiptHandle != "" && m.receiptHandle == receiptHandle {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return nil
+		}
+	}
+
+	return awserr.New(sqs.ErrCodeReceiptHandleIsInvalid, fmt.Sprintf("the receipt handle '%v' is not valid", receiptHandle), nil)
+}
+
+func (q *queue) changeVisibility(receiptHandle string, seconds int64, now time.Time) error {
+	for _, m := range q.messages {
+		if m.receiptHandle != "" && m.receiptHandle == receiptHandle {
+			if !m.visibleAt.After(now) {
+				return awserr.New(sqs.ErrCodeMessageNotInflight, "the message is not in flight", nil)
+			}
+
+			m.visibleAt = now.Add(time.Duration(seconds) * time.Second)
+			return nil
+		}
+	}
+
+	return awserr.New(sqs.ErrCodeReceiptHandleIsInvalid, fmt.Sprintf("the receipt handle '%v' is not valid", receiptHandle), nil)
+}
+
+// toSQS converts a message to what ReceiveMessage would return with the requested attribute names
+func (m *message) toSQS(q *queue, attributeNames []string, messageAttributeNames []string) *sqs.Message {
+	system := map[string]string{
+		sqs.MessageSystemAttributeNameSenderId:                         senderID,
+		sqs.MessageSystemAttributeNameSentTimestamp:                    millis(m.sentAt),
+		sqs.MessageSystemAttributeNameApproximateReceiveCount:          strconv.Itoa(m.receiveCount),
+		sqs.MessageSystemAttributeNameApproximateFirstReceiveTimestamp: millis(m.firstReceiveAt),
+	}
+
+	if q.fifo {
+		system["MessageGroupId"] = m.groupID
+		system["MessageDeduplicationId"] = m.deduplicationID
+		system[sqs.MessageSystemAttributeNameSequenceNumber] = m.sequenceNumber
+	}
+
+	attributes := make(map[string]*string)
+	for k, v := range system {
+		if wants(attributeNames, k) {
+			attributes[k] = aws.String(v)
+		}
+	}
+
+	var messageAttributes map[string]*sqs.MessageAttributeValue
+	for k, v := range m.messageAttributes {
+		if !wantsMessageAttribute(messageAttributeNames, k) {
+			continue
+		}
+
+		if messageAttributes == nil {
+			messageAttributes = make(map[string]*sqs.MessageAttributeValue)
+		}
+
+		copied := *v
+		messageAttributes[k] = &copied
+	}
+
+	out := &sqs.Message{
+		MessageId:         aws.String(m.id),
+		Body:              aws.String(m.body),
+		MD5OfBody:         aws.String(md5Hex(m.body)),
+		MessageAttributes: messageAttributes,
+	}
+
+	if len(attributes) > 0 {
+		out.Attributes = attributes
+	}
+
+	if m.receiptHandle != "" {
+		out.ReceiptHandle = aws.String(m.receiptHandle)
+	}
+
+	return out
+}
+
+// wants reports whether the attribute was asked for by name or with All. SQS accepts All in any case.
+func wants(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, sqs.QueueAttributeNameAll) || n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wantsMessageAttribute also understands the .* suffix SQS allows for message attribute names
+func wantsMessageAttribute(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, sqs.QueueAttributeNameAll) || n == ".*" || n == name {
+			return true
+		}
+
+		if strings.HasSuffix(n, ".*") && strings.HasPrefix(name, strings.TrimSuffix(n, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateBatch(ids []*string) error {
+	if len(ids) == 0 {
+		return awserr.New(sqs.ErrCodeEmptyBatchRequest, "the batch request doesn't contain any entries", nil)
+	}
+
+	if len(ids) > maxBatchEntries {
+		return awserr.New(
+			sqs.ErrCodeTooManyEntriesInBatchRequest,
+			fmt.Sprintf("maximum number of entries per request are %v. you have sent %v", maxBatchEntries, len(ids)),
+			nil,
+		)
+	}
+
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id == nil || *id == "" {
+			return awserr.New(sqs.ErrCodeInvalidBatchEntryId, "every batch entry needs an Id", nil)
+		}
+
+		if seen[*id] {
+			return awserr.New(sqs.ErrCodeBatchEntryIdsNotDistinct, fmt.Sprintf("Id %v repeated", *id), nil)
+		}
+		seen[*id] = true
+	}
+
+	return nil
+}
+
+// messageSize counts the body and every attribute name, type, and value the way SQS does
+func messageSize(body *string, attributes map[string]*sqs.MessageAttributeValue) int {
+	size := len(aws.StringValue(body))
+	for k, v := range attributes {
+		if v == nil {
+			continue
+		}
+
+		size += len(k) + len(aws.StringValue(v.DataType)) + len(aws.StringValue(v.StringValue)) + len(v.BinaryValue)
+	}
+
+	return size
+}
+
+func batchError(id *string, err error) *sqs.BatchResultErrorEntry {
+	entry := &sqs.BatchResultErrorEntry{
+		Id:          id,
+		Code:        aws.String("InternalError"),
+		Message:     aws.String(err.Error()),
+		SenderFault: aws.Bool(true),
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		entry.Code = aws.String(aerr.Code())
+		entry.Message = aws.String(aerr.Message())
+	}
+
+	return entry
+}
+
+func queueDoesNotExist(queue string) error {
+	return awserr.New(sqs.ErrCodeQueueDoesNotExist, fmt.Sprintf("the specified queue %v does not exist", queue), nil)
+}
+
+func contextError(ctx aws.Context) error {
+	if ctx == nil {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+
+	return nil
+}
+
+func millis(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return aws.String(s)
+}
+
+// Compile time check that the fake can be used in place of a real client
+var _ sqsiface.SQSAPI = (*SQS)(nil)
@@ -0,0 +1,559 @@
+package sqsdrtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func receive(t *testing.T, s *SQS, queueURL string, max int64) []*sqs.Message {
+	t.Helper()
+
+	resp, err := s.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MaxNumberOfMessages:   aws.Int64(max),
+		AttributeNames:        aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+	})
+	if err != nil {
+		t.Fatalf("ReceiveMessage returned an error: %v", err)
+	}
+
+	return resp.Messages
+}
+
+func bodies(msgs []*sqs.Message) []string {
+	out := make([]string, len(msgs))
+	for i, msg := range msgs {
+		out[i] = aws.StringValue(msg.Body)
+	}
+
+	return out
+}
+
+func errorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+
+	return ""
+}
+
+func TestCreateQueue(t *testing.T) {
+	s := NewSQS()
+
+	queueURL := s.MustCreateQueue("orders")
+	if queueURL != "https://sqs.us-east-1.amazonaws.com/123456789012/orders" {
+		t.Errorf("MustCreateQueue returned %v", queueURL)
+	}
+
+	if again := s.MustCreateQueue("orders"); again != queueURL {
+		t.Errorf("creating an existing queue returned %v; want %v", again, queueURL)
+	}
+
+	_, err := s.CreateQueue(&sqs.CreateQueueInput{
+		QueueName:  aws.String("orders"),
+		Attributes: map[string]*string{sqs.QueueAttributeNameFifoQueue: aws.String("true")},
+	})
+	if err == nil {
+		t.Error("CreateQueue of a FIFO queue without the .fifo suffix did not return an error")
+	}
+
+	resp, err := s.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String("orders")})
+	if err != nil || aws.StringValue(resp.QueueUrl) != queueURL {
+		t.Errorf("GetQueueUrl = %v, %v; want %v", resp, err, queueURL)
+	}
+
+	_, err = s.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String("missing")})
+	if errorCode(err) != sqs.ErrCodeQueueDoesNotExist {
+		t.Errorf("GetQueueUrl of a missing queue returned %v; want %v", err, sqs.ErrCodeQueueDoesNotExist)
+	}
+
+	_, err = s.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String("orders"), QueueOwnerAWSAccountId: aws.String("999999999999")})
+	if errorCode(err) != sqs.ErrCodeQueueDoesNotExist {
+		t.Errorf("GetQueueUrl with another account returned %v; want %v", err, sqs.ErrCodeQueueDoesNotExist)
+	}
+}
+
+func TestListAndDeleteQueues(t *testing.T) {
+	s := NewSQS()
+	orders := s.MustCreateQueue("orders")
+	ordersDLQ := s.MustCreateQueue("orders-dlq")
+	s.MustCreateQueue("payments")
+
+	resp, err := s.ListQueues(&sqs.ListQueuesInput{QueueNamePrefix: aws.String("orders")})
+	if err != nil {
+		t.Fatalf("ListQueues returned an error: %v", err)
+	}
+
+	urls := aws.StringValueSlice(resp.QueueUrls)
+	if len(urls) != 2 || urls[0] != orders || urls[1] != ordersDLQ {
+		t.Errorf("ListQueues = %v; want %v and %v", urls, orders, ordersDLQ)
+	}
+
+	_, err = s.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(orders)})
+	if err != nil {
+		t.Fatalf("DeleteQueue returned an error: %v", err)
+	}
+
+	if got := s.QueueURLs(); len(got) != 2 {
+		t.Errorf("QueueURLs = %v after deleting a queue; want 2", got)
+	}
+
+	_, err = s.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(orders)})
+	if errorCode(err) != sqs.ErrCodeQueueDoesNotExist {
+		t.Errorf("deleting a deleted queue returned %v; want %v", err, sqs.ErrCodeQueueDoesNotExist)
+	}
+}
+
+func TestListQueuesReturnsAtMost1000(t *testing.T) {
+	s := NewSQS()
+	for i := 0; i < 1001; i++ {
+		s.MustCreateQueue(fmt.Sprintf("queue-%04d", i))
+	}
+
+	resp, err := s.ListQueues(&sqs.ListQueuesInput{})
+	if err != nil {
+		t.Fatalf("ListQueues returned an error: %v", err)
+	}
+
+	if len(resp.QueueUrls) != 1000 {
+		t.Errorf("ListQueues returned %v queues; want 1000", len(resp.QueueUrls))
+	}
+}
+func TestTags(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+
+	_, err := s.TagQueue(&sqs.TagQueueInput{QueueUrl: aws.String(queueURL), Tags: aws.StringMap(map[string]string{"team": "checkout"})})
+	if err != nil {
+		t.Fatalf("TagQueue returned an error: %v", err)
+	}
+
+	resp, err := s.ListQueueTags(&sqs.ListQueueTagsInput{QueueUrl: aws.String(queueURL)})
+	if err != nil {
+		t.Fatalf("ListQueueTags returned an error: %v", err)
+	}
+
+	if got := aws.StringValue(resp.Tags["team"]); got != "checkout" {
+		t.Errorf("team tag = %q; want checkout", got)
+	}
+}
+
+func TestReceiveHidesMessagesForTheVisibilityTimeout(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+	s.MustSendMessage(queueURL, "one")
+	s.MustSendMessage(queueURL, "two")
+
+	msgs := receive(t, s, queueURL, 10)
+	if got := bodies(msgs); len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Fatalf("received %v; want one and two", got)
+	}
+
+	if got := aws.StringValue(msgs[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]); got != "1" {
+		t.Errorf("ApproximateReceiveCount = %v; want 1", got)
+	}
+
+	if msgs := receive(t, s, queueURL, 10); len(msgs) != 0 {
+		t.Errorf("received %v while the messages were in flight", bodies(msgs))
+	}
+
+	attrs, err := s.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+	})
+	if err != nil {
+		t.Fatalf("GetQueueAttributes returned an error: %v", err)
+	}
+
+	if got := aws.StringValue(attrs.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible]); got != "2" {
+		t.Errorf("ApproximateNumberOfMessagesNotVisible = %v; want 2", got)
+	}
+
+	s.Advance(defaultVisibilityTimeout)
+
+	again := receive(t, s, queueURL, 10)
+	if len(again) != 2 {
+		t.Fatalf("received %v after the visibility timeout; want both messages", bodies(again))
+	}
+
+	if got := aws.StringValue(again[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]); got != "2" {
+		t.Errorf("ApproximateReceiveCount = %v; want 2", got)
+	}
+}
+
+func TestReceiveValidatesMaxNumberOfMessages(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+
+	_, err := s.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: aws.String(queueURL), MaxNumberOfMessages: aws.Int64(11)})
+	if err == nil {
+		t.Error("ReceiveMessage of 11 messages did not return an error")
+	}
+}
+
+func TestDeleteUsesTheLatestReceiptHandle(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+	s.MustSendMessage(queueURL, "one")
+
+	stale := receive(t, s, queueURL, 1)[0]
+	s.Advance(defaultVisibilityTimeout)
+	fresh := receive(t, s, queueURL, 1)[0]
+
+	_, err := s.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: stale.ReceiptHandle})
+	if errorCode(err) != sqs.ErrCodeReceiptHandleIsInvalid {
+		t.Errorf("deleting with a stale receipt handle returned %v; want %v", err, sqs.ErrCodeReceiptHandleIsInvalid)
+	}
+
+	_, err = s.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: fresh.ReceiptHandle})
+	if err != nil {
+		t.Fatalf("DeleteMessage returned an error: %v", err)
+	}
+
+	if msgs := s.Messages(queueURL); len(msgs) != 0 {
+		t.Errorf("queue still has %v", bodies(msgs))
+	}
+}
+
+func TestSendMessageBatch(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+
+	resp, err := s.SendMessageBatch(&sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries: []*sqs.SendMessageBatchRequestEntry{
+			{Id: aws.String("a"), MessageBody: aws.String("one")},
+			{Id: aws.String("b"), MessageBody: aws.String("")},
+			{
+				Id:          aws.String("c"),
+				MessageBody: aws.String("three"),
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{
+					"trace": {DataType: aws.String("String"), StringValue: aws.String("abc")},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SendMessageBatch returned an error: %v", err)
+	}
+
+	if len(resp.Successful) != 2 || len(resp.Failed) != 1 || aws.StringValue(resp.Failed[0].Id) != "b" {
+		t.Fatalf("SendMessageBatch = %v; want b to fail", resp)
+	}
+
+	msgs := s.Messages(queueURL)
+	if got := bodies(msgs); len(got) != 2 || got[0] != "one" || got[1] != "three" {
+		t.Fatalf("queue has %v; want one and three", got)
+	}
+
+	if got := aws.StringValue(msgs[1].MessageAttributes["trace"].StringValue); got != "abc" {
+		t.Errorf("trace attribute = %q; want abc", got)
+	}
+}
+
+func TestSendMessageBatchValidation(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+
+	tooMany := make([]*sqs.SendMessageBatchRequestEntry, 11)
+	for i := range tooMany {
+		tooMany[i] = &sqs.SendMessageBatchRequestEntry{Id: aws.String(string(rune('a' + i))), MessageBody: aws.String("x")}
+	}
+
+	tests := []struct {
+		name    string
+		entries []*sqs.SendMessageBatchRequestEntry
+		code    string
+	}{
+		{name: "empty", code: sqs.ErrCodeEmptyBatchRequest},
+		{name: "too many", entries: tooMany, code: sqs.ErrCodeTooManyEntriesInBatchRequest},
+		{
+			name: "repeated ids",
+			entries: []*sqs.SendMessageBatchRequestEntry{
+				{Id: aws.String("a"), MessageBody: aws.String("one")},
+				{Id: aws.String("a"), MessageBody: aws.String("two")},
+			},
+			code: sqs.ErrCodeBatchEntryIdsNotDistinct,
+		},
+		{
+			name: "too long",
+			entries: []*sqs.SendMessageBatchRequestEntry{
+				{Id: aws.String("a"), MessageBody: aws.String(strings.Repeat("x", maxMessageSize))},
+				{Id: aws.String("b"), MessageBody: aws.String("x")},
+			},
+			code: sqs.ErrCodeBatchRequestTooLong,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.SendMessageBatch(&sqs.SendMessageBatchInput{QueueUrl: aws.String(queueURL), Entries: tt.entries})
+			if errorCode(err) != tt.code {
+				t.Errorf("SendMessageBatch returned %v; want %v", err, tt.code)
+			}
+		})
+	}
+
+	if msgs := s.Messages(queueURL); len(msgs) != 0 {
+		t.Errorf("rejected batches sent %v", bodies(msgs))
+	}
+}
+
+func TestFailSendEntry(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+	s.FailSendEntry = func(queueURL string, entry *sqs.SendMessageBatchRequestEntry) *sqs.BatchResultErrorEntry {
+		if aws.StringValue(entry.MessageBody) != "bad" {
+			return nil
+		}
+
+		return &sqs.BatchResultErrorEntry{Code: aws.String("InternalError"), SenderFault: aws.Bool(false)}
+	}
+
+	resp, err := s.SendMessageBatch(&sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries: []*sqs.SendMessageBatchRequestEntry{
+			{Id: aws.String("a"), MessageBody: aws.String("good")},
+			{Id: aws.String("b"), MessageBody: aws.String("bad")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SendMessageBatch returned an error: %v", err)
+	}
+
+	if len(resp.Failed) != 1 || aws.StringValue(resp.Failed[0].Id) != "b" {
+		t.Errorf("Failed = %v; want b with its Id filled in", resp.Failed)
+	}
+
+	if got := bodies(s.Messages(queueURL)); len(got) != 1 || got[0] != "good" {
+		t.Errorf("queue has %v; want only good", got)
+	}
+}
+
+func TestFailDeleteEntry(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+	s.MustSendMessage(queueURL, "keep")
+	s.MustSendMessage(queueURL, "delete")
+	s.FailDeleteEntry = func(queueURL string, entry *sqs.DeleteMessageBatchRequestEntry) *sqs.BatchResultErrorEntry {
+		if aws.StringValue(entry.Id) != "keep" {
+			return nil
+		}
+
+		return &sqs.BatchResultErrorEntry{Code: aws.String("InternalError"), SenderFault: aws.Bool(false)}
+	}
+
+	msgs := receive(t, s, queueURL, 10)
+	resp, err := s.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries: []*sqs.DeleteMessageBatchRequestEntry{
+			{Id: aws.String("keep"), ReceiptHandle: msgs[0].ReceiptHandle},
+			{Id: aws.String("delete"), ReceiptHandle: msgs[1].ReceiptHandle},
+		},
+	})
+	if err != nil {
+		t.Fatalf("DeleteMessageBatch returned an error: %v", err)
+	}
+
+	if len(resp.Failed) != 1 || aws.StringValue(resp.Failed[0].Id) != "keep" {
+		t.Errorf("Failed = %v; want keep", resp.Failed)
+	}
+
+	if got := bodies(s.Messages(queueURL)); len(got) != 1 || got[0] != "keep" {
+		t.Errorf("queue has %v; want only keep", got)
+	}
+}
+
+func TestStandardQueuesRejectFIFOParameters(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+
+	_, err := s.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:       aws.String(queueURL),
+		MessageBody:    aws.String("one"),
+		MessageGroupId: aws.String("group"),
+	})
+	if err == nil {
+		t.Error("SendMessage with a MessageGroupId to a standard queue did not return an error")
+	}
+}
+
+func TestFIFODeduplication(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders.fifo")
+
+	send := func(body string, dedupID string) {
+		req := &sqs.SendMessageInput{
+			QueueUrl:       aws.String(queueURL),
+			MessageBody:    aws.String(body),
+			MessageGroupId: aws.String("group"),
+		}
+		if dedupID != "" {
+			req.MessageDeduplicationId = aws.String(dedupID)
+		}
+
+		_, err := s.SendMessage(req)
+		if err != nil {
+			t.Fatalf("SendMessage returned an error: %v", err)
+		}
+	}
+
+	send("one", "")
+	send("one", "")
+	send("two", "id")
+	send("three", "id")
+
+	if got := bodies(s.Messages(queueURL)); len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Fatalf("queue has %v; want one and two", got)
+	}
+
+	s.Advance(deduplicationInterval)
+	send("one", "")
+
+	if got := bodies(s.Messages(queueURL)); len(got) != 3 {
+		t.Errorf("queue has %v; want one to be sent again after the deduplication interval", got)
+	}
+
+	_, err := s.SendMessage(&sqs.SendMessageInput{QueueUrl: aws.String(queueURL), MessageBody: aws.String("four")})
+	if err == nil {
+		t.Error("SendMessage without a MessageGroupId to a FIFO queue did not return an error")
+	}
+}
+
+func TestFIFOGroupsAreReceivedInOrder(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders.fifo")
+
+	for _, m := range []struct{ group, body string }{{"a", "a1"}, {"b", "b1"}, {"a", "a2"}} {
+		_, err := s.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:       aws.String(queueURL),
+			MessageBody:    aws.String(m.body),
+			MessageGroupId: aws.String(m.group),
+		})
+		if err != nil {
+			t.Fatalf("SendMessage returned an error: %v", err)
+		}
+	}
+
+	first := receive(t, s, queueURL, 1)
+	if got := bodies(first); len(got) != 1 || got[0] != "a1" {
+		t.Fatalf("received %v; want a1", got)
+	}
+
+	if got := aws.StringValue(first[0].Attributes["MessageGroupId"]); got != "a" {
+		t.Errorf("MessageGroupId = %q; want a", got)
+	}
+
+	// a2 waits for a1 while group b carries on
+	if got := bodies(receive(t, s, queueURL, 10)); len(got) != 1 || got[0] != "b1" {
+		t.Fatalf("received %v while a1 was in flight; want b1", got)
+	}
+
+	_, err := s.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: first[0].ReceiptHandle})
+	if err != nil {
+		t.Fatalf("DeleteMessage returned an error: %v", err)
+	}
+
+	if got := bodies(receive(t, s, queueURL, 10)); len(got) != 1 || got[0] != "a2" {
+		t.Errorf("received %v after deleting a1; want a2", got)
+	}
+}
+
+func TestMessageAttributeNames(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+
+	_, err := s.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String("one"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"trace.id":   {DataType: aws.String("String"), StringValue: aws.String("abc")},
+			"trace.span": {DataType: aws.String("String"), StringValue: aws.String("def")},
+			"tenant":     {DataType: aws.String("String"), StringValue: aws.String("acme")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage returned an error: %v", err)
+	}
+
+	resp, err := s.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MessageAttributeNames: aws.StringSlice([]string{"trace.*"}),
+	})
+	if err != nil {
+		t.Fatalf("ReceiveMessage returned an error: %v", err)
+	}
+
+	attrs := resp.Messages[0].MessageAttributes
+	if len(attrs) != 2 || attrs["trace.id"] == nil || attrs["trace.span"] == nil {
+		t.Errorf("MessageAttributes = %v; want only the trace attributes", attrs)
+	}
+
+	if resp.Messages[0].Attributes != nil {
+		t.Errorf("Attributes = %v; want none since none were asked for", resp.Messages[0].Attributes)
+	}
+}
+
+func TestChangeMessageVisibilityBatch(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+	s.MustSendMessage(queueURL, "extend")
+	s.MustSendMessage(queueURL, "release")
+
+	msgs := receive(t, s, queueURL, 10)
+	resp, err := s.ChangeMessageVisibilityBatch(&sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries: []*sqs.ChangeMessageVisibilityBatchRequestEntry{
+			{Id: aws.String("a"), ReceiptHandle: msgs[0].ReceiptHandle, VisibilityTimeout: aws.Int64(120)},
+			{Id: aws.String("b"), ReceiptHandle: msgs[1].ReceiptHandle, VisibilityTimeout: aws.Int64(0)},
+			{Id: aws.String("c"), ReceiptHandle: aws.String("bogus"), VisibilityTimeout: aws.Int64(0)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ChangeMessageVisibilityBatch returned an error: %v", err)
+	}
+
+	if len(resp.Failed) != 1 || aws.StringValue(resp.Failed[0].Id) != "c" {
+		t.Errorf("Failed = %v; want c", resp.Failed)
+	}
+
+	if got := bodies(receive(t, s, queueURL, 10)); len(got) != 1 || got[0] != "release" {
+		t.Errorf("received %v; want only release", got)
+	}
+
+	s.Advance(time.Minute)
+	if got := bodies(receive(t, s, queueURL, 10)); len(got) != 1 || got[0] != "release" {
+		t.Errorf("received %v before the extended timeout passed; want only release", got)
+	}
+
+	s.Advance(90 * time.Second)
+	if got := bodies(receive(t, s, queueURL, 10)); len(got) != 2 {
+		t.Errorf("received %v after the extended timeout; want both", got)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	s := NewSQS()
+	queueURL := s.MustCreateQueue("orders")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.SendMessageWithContext(ctx, &sqs.SendMessageInput{QueueUrl: aws.String(queueURL), MessageBody: aws.String("one")})
+	if errorCode(err) != request.CanceledErrorCode {
+		t.Errorf("SendMessageWithContext returned %v; want %v", err, request.CanceledErrorCode)
+	}
+
+	if msgs := s.Messages(queueURL); len(msgs) != 0 {
+		t.Errorf("a cancelled send added %v", bodies(msgs))
+	}
+}
@@ -0,0 +1,496 @@
+package sqsdr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/jmespath/go-jmespath"
+)
+
+const (
+	defaultStatsTopN = 10
+
+	// noGroup is the group of messages the GroupBy expressions found nothing in
+	noGroup = "(none)"
+
+	maxSignatureLength = 120
+
+	// maxReceiveCountBucket groups every receive count above it together
+	maxReceiveCountBucket = 10
+)
+
+var (
+	// ageBuckets are the upper bounds of the age distribution. Anything older goes in the last bucket.
+	ageBuckets = []struct {
+		label string
+		max   time.Duration
+	}{
+		{"< 1m", time.Minute},
+		{"< 1h", time.Hour},
+		{"< 1d", 24 * time.Hour},
+		{"< 7d", 7 * 24 * time.Hour},
+	}
+
+	// signatureReplacements turn the parts of an error message that change from message to message into
+	// placeholders so that the same error is counted once
+	signatureReplacements = []struct {
+		pattern     *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+		{regexp.MustCompile(`\b(0x)?[0-9a-fA-F]{8,}\b`), "<hex>"},
+		{regexp.MustCompile(`\d+(\.\d+)?`), "<n>"},
+		{regexp.MustCompile(`\s+`), " "},
+	}
+)
+
+// Stats scans a queue without changing it and reports what is in it: the number of messages in each group, how old
+// they are, how often they've been received, how big their bodies are, and the most common error signatures. It
+// reads the queue like Peek and makes every message visible again at the end.
+type Stats struct {
+	SourceClient   sqsiface.SQSAPI
+	SourceQueueURL string
+	Out            io.Writer
+
+	// Format is FormatTable or FormatJSON. Defaults to FormatTable.
+	Format string
+
+	// Chooser optionally limits the report to the messages it sends to the left
+	Chooser Chooser
+
+	// GroupByJMESPath and GroupByRegex optionally count messages by a key. The JMESPath runs against the body and
+	// the regex against its output, or the whole body without a JMESPath. The first capture group of the regex is
+	// the key, or the whole match if it has none.
+	GroupByJMESPath string
+	GroupByRegex    string
+
+	// SignatureJMESPath optionally picks the error message out of the body. The whole body is used without it.
+	SignatureJMESPath string
+
+	// TopN is the number of groups and error signatures to report. Defaults to 10.
+	TopN int
+
+	// VisibilityTimeout is the number of seconds messages are hidden for while the queue is read. See Peek.
+	VisibilityTimeout int64
+
+	// Concurrency is the number of workers polling the source queue
+	Concurrency int
+
+	// Observer is optionally told how many messages were received
+	Observer Observer
+
+	// Resolver optionally fetches offloaded bodies so that the Chooser and the report see the real ones
+	Resolver PayloadResolver
+
+	StopConditions
+}
+
+// StatsReport is what Stats found in the queue
+type StatsReport struct {
+	Messages int64
+
+	// Groups are the largest groups when grouping was asked for
+	Groups []StatsCount `json:",omitempty"`
+
+	// Ages is the distribution of time since SentTimestamp
+	Ages          []StatsCount
+	OldestMessage *time.Time `json:",omitempty"`
+	NewestMessage *time.Time `json:",omitempty"`
+
+	// ReceiveCounts is the distribution of ApproximateReceiveCount. It counts the receive made by Stats too.
+	ReceiveCounts []StatsCount
+
+	BodySize BodySizeStats
+
+	// ErrorSignatures are the most common bodies, or SignatureJMESPath outputs, once the IDs, numbers, and
+	// whitespace that change between messages have been taken out
+	ErrorSignatures []StatsCount
+}
+
+// StatsCount is the number of messages with a key
+type StatsCount struct {
+	Key   string
+	Count int64
+}
+
+// BodySizeStats are percentiles of the body size in bytes
+type BodySizeStats struct {
+	Min int
+	P50 int
+	P90 int
+	P99 int
+	Max int
+}
+
+// Stats is the entry point into the stats strategy. Cancelling ctx stops after the batches in flight without
+// writing the report.
+func (s *Stats) Stats(ctx context.Context) error {
+	switch s.Format {
+	case FormatTable, "", FormatJSON:
+	default:
+		return fmt.Errorf("unknown stats format '%v'. expected one of: %v, %v", s.Format, FormatTable, FormatJSON)
+	}
+
+	report, err := s.Report(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.Format == FormatJSON {
+		encoder := json.NewEncoder(s.Out)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(report)
+	}
+
+	return report.writeTable(s.Out)
+}
+
+// Report scans the queue and returns the report without writing it
+func (s *Stats) Report(ctx context.Context) (*StatsReport, error) {
+	sink, err := s.newStatsSink()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Peek{
+		SourceClient:      s.SourceClient,
+		SourceQueueURL:    s.SourceQueueURL,
+		VisibilityTimeout: s.VisibilityTimeout,
+		ResetVisibility:   true,
+		Concurrency:       s.Concurrency,
+		Observer:          s.Observer,
+		Resolver:          s.Resolver,
+		StopConditions:    s.StopConditions,
+	}
+
+	err = p.peek(ctx, sink)
+	if err != nil {
+		return nil, err
+	}
+
+	return sink.report(time.Now()), nil
+}
+
+func (s *Stats) newStatsSink() (*statsSink, error) {
+	sink := &statsSink{
+		chooser:       s.Chooser,
+		topN:          s.TopN,
+		groups:        make(map[string]int64),
+		receiveCounts: make(map[int]int64),
+		signatures:    make(map[string]int64),
+	}
+
+	if sink.chooser == nil {
+		sink.chooser = &PassthroughChooser{}
+	}
+
+	if sink.topN <= 0 {
+		sink.topN = defaultStatsTopN
+	}
+
+	var err error
+	if s.GroupByJMESPath != "" {
+		sink.groupByJMESPath, err = jmespath.Compile(s.GroupByJMESPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile the group by JMESPath: %v", err)
+		}
+	}
+
+	if s.GroupByRegex != "" {
+		sink.groupByRegex, err = regexp.Compile(s.GroupByRegex)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile the group by regular expression: %v", err)
+		}
+	}
+
+	if s.SignatureJMESPath != "" {
+		sink.signatureJMESPath, err = jmespath.Compile(s.SignatureJMESPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile the signature JMESPath: %v", err)
+		}
+	}
+
+	return sink, nil
+}
+
+// statsSink tallies every message it is given
+type statsSink struct {
+	chooser           Chooser
+	topN              int
+	groupByJMESPath   *jmespath.JMESPath
+	groupByRegex      *regexp.Regexp
+	signatureJMESPath *jmespath.JMESPath
+
+	mu            sync.Mutex
+	messages      int64
+	groups        map[string]int64
+	sentAt        []time.Time
+	receiveCounts map[int]int64
+	bodySizes     []int
+	signatures    map[string]int64
+}
+
+func (s *statsSink) Sink(ctx context.Context, msgs []*sqs.Message) error {
+	left, _ := s.chooser.Choose(msgs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, msg := range left {
+		s.messages++
+		s.bodySizes = append(s.bodySizes, len(aws.StringValue(msg.Body)))
+
+		if s.grouping() {
+			s.groups[s.group(msg)]++
+		}
+
+		if sent, ok := sentTimestamp(msg); ok {
+			s.sentAt = append(s.sentAt, sent)
+		}
+
+		if n, err := strconv.Atoi(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount])); err == nil {
+			if n > maxReceiveCountBucket {
+				n = maxReceiveCountBucket + 1
+			}
+			s.receiveCounts[n]++
+		}
+
+		s.signatures[s.signature(msg)]++
+	}
+
+	return nil
+}
+
+func (s *statsSink) grouping() bool {
+	return s.groupByJMESPath != nil || s.groupByRegex != nil
+}
+
+// group returns the key the message is counted under
+func (s *statsSink) group(msg *sqs.Message) string {
+	key := aws.StringValue(msg.Body)
+
+	if s.groupByJMESPath != nil {
+		body, err := jsonBody(msg)
+		if err != nil {
+			return noGroup
+		}
+
+		key = searchString(s.groupByJMESPath, body)
+		if key == "" {
+			return noGroup
+		}
+	}
+
+	if s.groupByRegex == nil {
+		return key
+	}
+
+	match := s.groupByRegex.FindStringSubmatch(key)
+	switch {
+	case match == nil:
+		return noGroup
+	case len(match) > 1:
+		return match[1]
+	default:
+		return match[0]
+	}
+}
+
+// signature returns the error message of the message with the parts that change between messages taken out
+func (s *statsSink) signature(msg *sqs.Message) string {
+	text := aws.StringValue(msg.Body)
+
+	if s.signatureJMESPath != nil {
+		body, err := jsonBody(msg)
+		if err != nil {
+			return noGroup
+		}
+
+		text = searchString(s.signatureJMESPath, body)
+		if text == "" {
+			return noGroup
+		}
+	}
+
+	for _, r := range signatureReplacements {
+		text = r.pattern.ReplaceAllString(text, r.replacement)
+	}
+
+	return truncate(strings.TrimSpace(text), maxSignatureLength)
+}
+
+func (s *statsSink) report(now time.Time) *StatsReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &StatsReport{
+		Messages:        s.messages,
+		Ages:            ageDistribution(s.sentAt, now),
+		ReceiveCounts:   receiveCountDistribution(s.receiveCounts),
+		BodySize:        bodySizePercentiles(s.bodySizes),
+		ErrorSignatures: topCounts(s.signatures, s.topN),
+	}
+
+	if s.grouping() {
+		r.Groups = topCounts(s.groups, s.topN)
+	}
+
+	for i := range s.sentAt {
+		sent := s.sentAt[i]
+		if r.OldestMessage == nil || sent.Before(*r.OldestMessage) {
+			r.OldestMessage = &sent
+		}
+
+		if r.NewestMessage == nil || sent.After(*r.NewestMessage) {
+			r.NewestMessage = &sent
+		}
+	}
+
+	return r
+}
+
+func (r *StatsReport) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "messages\t%v\n", r.Messages)
+	if r.OldestMessage != nil {
+		fmt.Fprintf(w, "oldest message\t%v\n", r.OldestMessage.Format(time.RFC3339))
+		fmt.Fprintf(w, "newest message\t%v\n", r.NewestMessage.Format(time.RFC3339))
+	}
+
+	if r.Groups != nil {
+		writeCounts(w, "group", r.Groups)
+	}
+
+	writeCounts(w, "age", r.Ages)
+	writeCounts(w, "receive count", r.ReceiveCounts)
+
+	fmt.Fprintf(w, "\nbody size (bytes)\t\n")
+	fmt.Fprintf(w, "min\t%v\n", r.BodySize.Min)
+	fmt.Fprintf(w, "p50\t%v\n", r.BodySize.P50)
+	fmt.Fprintf(w, "p90\t%v\n", r.BodySize.P90)
+	fmt.Fprintf(w, "p99\t%v\n", r.BodySize.P99)
+	fmt.Fprintf(w, "max\t%v\n", r.BodySize.Max)
+
+	writeCounts(w, "error signature", r.ErrorSignatures)
+
+	return w.Flush()
+}
+
+func writeCounts(w io.Writer, title string, counts []StatsCount) {
+	fmt.Fprintf(w, "\n%v\tcount\n", title)
+	for _, c := range counts {
+		fmt.Fprintf(w, "%v\t%v\n", c.Key, c.Count)
+	}
+}
+
+// ageDistribution counts the messages in each of the ageBuckets
+func ageDistribution(sentAt []time.Time, now time.Time) []StatsCount {
+	counts := make([]StatsCount, len(ageBuckets)+1)
+	for i, b := range ageBuckets {
+		counts[i].Key = b.label
+	}
+	counts[len(ageBuckets)].Key = ">= 7d"
+
+	for _, sent := range sentAt {
+		age := now.Sub(sent)
+
+		i := 0
+		for i < len(ageBuckets) && age >= ageBuckets[i].max {
+			i++
+		}
+
+		counts[i].Count++
+	}
+
+	return counts
+}
+
+// receiveCountDistribution orders the receive counts from lowest to highest
+func receiveCountDistribution(receiveCounts map[int]int64) []StatsCount {
+	keys := make([]int, 0, len(receiveCounts))
+	for n := range receiveCounts {
+		keys = append(keys, n)
+	}
+	sort.Ints(keys)
+
+	counts := make([]StatsCount, len(keys))
+	for i, n := range keys {
+		key := strconv.Itoa(n)
+		if n > maxReceiveCountBucket {
+			key = fmt.Sprintf("> %v", maxReceiveCountBucket)
+		}
+
+		counts[i] = StatsCount{Key: key, Count: receiveCounts[n]}
+	}
+
+	return counts
+}
+
+// bodySizePercentiles uses the nearest rank method
+func bodySizePercentiles(sizes []int) BodySizeStats {
+	if len(sizes) == 0 {
+		return BodySizeStats{}
+	}
+
+	sorted := make([]int, len(sizes))
+	copy(sorted, sizes)
+	sort.Ints(sorted)
+
+	percentile := func(p float64) int {
+		// Nearest rank: the smallest size with at least p of the sizes at or below it
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i > len(sorted)-1 {
+			i = len(sorted) - 1
+		}
+
+		return sorted[i]
+	}
+
+	return BodySizeStats{
+		Min: sorted[0],
+		P50: percentile(0.50),
+		P90: percentile(0.90),
+		P99: percentile(0.99),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// topCounts returns the n largest counts with ties broken by key
+func topCounts(counts map[string]int64, n int) []StatsCount {
+	top := make([]StatsCount, 0, len(counts))
+	for k, v := range counts {
+		top = append(top, StatsCount{Key: k, Count: v})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+
+		return top[i].Key < top[j].Key
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	return top
+}
@@ -0,0 +1,142 @@
+package sqsdr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/iamatypeofwalrus/sqsdr/sqsdrtest"
+)
+
+func TestStatsReport(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(
+		s,
+		src,
+		`{"service":"checkout","error":"order 1234 timed out"}`,
+		`{"service":"checkout","error":"order 5678 timed out"}`,
+		`{"service":"payments","error":"card declined"}`,
+		"not json",
+	)
+
+	st := &Stats{
+		SourceClient:      s,
+		SourceQueueURL:    src,
+		GroupByJMESPath:   "service",
+		SignatureJMESPath: "error",
+	}
+	report, err := st.Report(context.Background())
+	if err != nil {
+		t.Fatalf("Report returned an error: %v", err)
+	}
+
+	if report.Messages != 4 {
+		t.Errorf("Messages = %v; want 4", report.Messages)
+	}
+
+	groups := make(map[string]int64)
+	for _, g := range report.Groups {
+		groups[g.Key] = g.Count
+	}
+
+	wantGroups := map[string]int64{"checkout": 2, "payments": 1, noGroup: 1}
+	if !reflect.DeepEqual(groups, wantGroups) {
+		t.Errorf("Groups = %v; want %v", groups, wantGroups)
+	}
+
+	if len(report.ErrorSignatures) == 0 || report.ErrorSignatures[0].Count != 2 {
+		t.Errorf("ErrorSignatures = %v; want the two timeouts to share a signature", report.ErrorSignatures)
+	}
+
+	if report.BodySize.Min != len("not json") {
+		t.Errorf("BodySize.Min = %v; want %v", report.BodySize.Min, len("not json"))
+	}
+
+	if report.OldestMessage == nil || report.NewestMessage == nil {
+		t.Error("Report did not include the oldest and newest messages")
+	}
+
+	// Stats only reads the queue
+	if n := len(s.Messages(src)); n != 4 {
+		t.Errorf("source has %v messages after Stats; want 4", n)
+	}
+}
+
+func TestStatsWithChooser(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, src, "timeout one", "timeout two", "declined")
+
+	chooser, err := NewFilterChooser("", "timeout")
+	if err != nil {
+		t.Fatalf("NewFilterChooser returned an error: %v", err)
+	}
+
+	var out bytes.Buffer
+	st := &Stats{SourceClient: s, SourceQueueURL: src, Out: &out, Format: FormatJSON, Chooser: chooser}
+	err = st.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats returned an error: %v", err)
+	}
+
+	var report StatsReport
+	err = json.Unmarshal(out.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("could not parse the JSON report %q: %v", out.String(), err)
+	}
+
+	if report.Messages != 2 {
+		t.Errorf("Messages = %v; want only the 2 chosen", report.Messages)
+	}
+}
+
+func TestStatsRejectsUnknownFormats(t *testing.T) {
+	s := sqsdrtest.NewSQS()
+	src := s.MustCreateQueue("orders-dlq")
+	sendBodies(s, src, "one")
+
+	st := &Stats{SourceClient: s, SourceQueueURL: src, Out: &bytes.Buffer{}, Format: "yaml"}
+	err := st.Stats(context.Background())
+	if err == nil {
+		t.Fatal("Stats with an unknown format did not return an error")
+	}
+
+	if n := len(s.Messages(src)); n != 1 {
+		t.Errorf("source has %v messages; want 1", n)
+	}
+}
+
+func TestBodySizePercentiles(t *testing.T) {
+	// sizes returns 1 through n in an order that isn't sorted
+	sizes := func(n int) []int {
+		s := make([]int, n)
+		for i := range s {
+			s[i] = n - i
+		}
+		return s
+	}
+
+	tests := []struct {
+		n    int
+		want BodySizeStats
+	}{
+		{1, BodySizeStats{Min: 1, P50: 1, P90: 1, P99: 1, Max: 1}},
+		{2, BodySizeStats{Min: 1, P50: 1, P90: 2, P99: 2, Max: 2}},
+		{10, BodySizeStats{Min: 1, P50: 5, P90: 9, P99: 10, Max: 10}},
+		{16, BodySizeStats{Min: 1, P50: 8, P90: 15, P99: 16, Max: 16}},
+		{100, BodySizeStats{Min: 1, P50: 50, P90: 90, P99: 99, Max: 100}},
+	}
+
+	for _, test := range tests {
+		if got := bodySizePercentiles(sizes(test.n)); got != test.want {
+			t.Errorf("bodySizePercentiles of 1 to %v = %+v; want %+v", test.n, got, test.want)
+		}
+	}
+
+	if got := bodySizePercentiles(nil); got != (BodySizeStats{}) {
+		t.Errorf("bodySizePercentiles of nothing = %+v; want the zero value", got)
+	}
+}
@@ -0,0 +1,262 @@
+package sqsdr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/jmespath/go-jmespath"
+)
+
+// Prefixes understood by ParseTransformExpression
+const (
+	transformJMESPathPrefix = "jmespath:"
+	transformTemplatePrefix = "template:"
+	transformMergePrefix    = "merge:"
+)
+
+// Transformer rewrites the body of a message. A Pipeline runs the messages its Chooser sends to the left through
+// a Transformer before they reach the LeftSink. Transformers must not modify the message that is passed in.
+type Transformer interface {
+	Transform(*sqs.Message) (*sqs.Message, error)
+}
+
+// ParseTransformExpression returns a Transformer for an expression of the form jmespath:<expression>,
+// template:<text/template>, or merge:<JSON merge patch>.
+func ParseTransformExpression(expr string) (Transformer, error) {
+	switch {
+	case strings.HasPrefix(expr, transformJMESPathPrefix):
+		return NewJMESPathTransformer(strings.TrimPrefix(expr, transformJMESPathPrefix))
+	case strings.HasPrefix(expr, transformTemplatePrefix):
+		return NewTemplateTransformer(strings.TrimPrefix(expr, transformTemplatePrefix))
+	case strings.HasPrefix(expr, transformMergePrefix):
+		return NewMergePatchTransformer(strings.TrimPrefix(expr, transformMergePrefix))
+	}
+
+	return nil, fmt.Errorf(
+		"transform expression must start with one of %v, %v, or %v",
+		transformJMESPathPrefix,
+		transformTemplatePrefix,
+		transformMergePrefix,
+	)
+}
+
+// NewJMESPathTransformer returns an initialized JMESPathTransformer if the expression compiles
+func NewJMESPathTransformer(expression string) (*JMESPathTransformer, error) {
+	compiled, err := jmespath.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("could not compile JMESPath in NewJMESPathTransformer: %v", err)
+	}
+
+	return &JMESPathTransformer{Expression: expression, compiled: compiled}, nil
+}
+
+// JMESPathTransformer replaces a JSON body with the output of a JMESPath projection
+type JMESPathTransformer struct {
+	Expression string
+
+	compiled *jmespath.JMESPath
+}
+
+// Transform runs the JMESPath against the body and uses the JSON output as the new body
+func (j *JMESPathTransformer) Transform(msg *sqs.Message) (*sqs.Message, error) {
+	body, err := jsonBody(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := j.compiled.Search(body)
+	if err != nil {
+		return nil, fmt.Errorf("jmespath threw an error: %v", err)
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert JMESPath output to JSON: %v", err)
+	}
+
+	return withBody(msg, string(b)), nil
+}
+
+// NewTemplateTransformer returns an initialized TemplateTransformer if the template parses. Templates have a json
+// function for writing values back out as JSON.
+func NewTemplateTransformer(text string) (*TemplateTransformer, error) {
+	tmpl, err := template.New("transform").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template in NewTemplateTransformer: %v", err)
+	}
+
+	return &TemplateTransformer{Template: tmpl}, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// TemplateTransformer replaces the body with the output of a text/template. See TemplateData for what is
+// available to the template.
+type TemplateTransformer struct {
+	Template *template.Template
+}
+
+// TemplateData is passed to the template of a TemplateTransformer
+type TemplateData struct {
+	// Body is the original body
+	Body string
+
+	// JSON is the body parsed as JSON. It is nil if the body isn't JSON.
+	JSON interface{}
+
+	// Attributes are the system attributes such as ApproximateReceiveCount
+	Attributes map[string]string
+
+	// MessageAttributes are the string values of the custom message attributes
+	MessageAttributes map[string]string
+}
+
+// Transform executes the template against the message
+func (t *TemplateTransformer) Transform(msg *sqs.Message) (*sqs.Message, error) {
+	data := TemplateData{
+		Attributes:        make(map[string]string, len(msg.Attributes)),
+		MessageAttributes: make(map[string]string, len(msg.MessageAttributes)),
+	}
+
+	if msg.Body != nil {
+		data.Body = *msg.Body
+		data.JSON, _ = jsonBody(msg)
+	}
+
+	for k, v := range msg.Attributes {
+		data.Attributes[k] = aws.StringValue(v)
+	}
+
+	for k, v := range msg.MessageAttributes {
+		if v != nil && v.StringValue != nil {
+			data.MessageAttributes[k] = *v.StringValue
+		}
+	}
+
+	var buf bytes.Buffer
+	err := t.Template.Execute(&buf, data)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute transform template: %v", err)
+	}
+
+	return withBody(msg, buf.String()), nil
+}
+
+// NewMergePatchTransformer returns an initialized MergePatchTransformer if the patch is valid JSON
+func NewMergePatchTransformer(patch string) (*MergePatchTransformer, error) {
+	var p interface{}
+	err := json.Unmarshal([]byte(patch), &p)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse JSON merge patch in NewMergePatchTransformer: %v", err)
+	}
+
+	return &MergePatchTransformer{Patch: p}, nil
+}
+
+// MergePatchTransformer applies an RFC 7386 JSON merge patch to a JSON body. Keys in the patch replace the keys in
+// the body, objects are merged recursively, and keys set to null are removed.
+type MergePatchTransformer struct {
+	Patch interface{}
+}
+
+// Transform applies the patch to the body
+func (m *MergePatchTransformer) Transform(msg *sqs.Message) (*sqs.Message, error) {
+	body, err := jsonBody(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(mergePatch(body, m.Patch))
+	if err != nil {
+		return nil, fmt.Errorf("could not convert patched body to JSON: %v", err)
+	}
+
+	return withBody(msg, string(b)), nil
+}
+
+// mergePatch implements the MergePatch function from RFC 7386
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+
+		targetObj[k] = mergePatch(targetObj[k], v)
+	}
+
+	return targetObj
+}
+
+// transform runs each message through the Transformer. Messages that fail to transform are logged and returned
+// separately so the caller can leave them where they are. Bodies that are S3 pointers are never transformed since
+// rewriting the pointer would lose the payload.
+func transform(t Transformer, msgs []*sqs.Message) ([]*sqs.Message, []*sqs.Message) {
+	transformed := make([]*sqs.Message, 0, len(msgs))
+	failed := make([]*sqs.Message, 0)
+
+	for _, msg := range msgs {
+		if _, ok := ParseS3Pointer(aws.StringValue(msg.Body)); ok {
+			log.Printf("not transforming message %v since its body is an S3 pointer\n", aws.StringValue(msg.MessageId))
+			failed = append(failed, msg)
+			continue
+		}
+
+		out, err := t.Transform(msg)
+		if err != nil {
+			log.Printf("could not transform message %v: %v\n", aws.StringValue(msg.MessageId), err)
+			failed = append(failed, msg)
+			continue
+		}
+
+		transformed = append(transformed, out)
+	}
+
+	return transformed, failed
+}
+
+// jsonBody parses the body of the message as JSON
+func jsonBody(msg *sqs.Message) (interface{}, error) {
+	if msg.Body == nil {
+		return nil, fmt.Errorf("message %v does not have a body", aws.StringValue(msg.MessageId))
+	}
+
+	var body interface{}
+	err := json.Unmarshal([]byte(*msg.Body), &body)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal sqs body into json: %v", err)
+	}
+
+	return body, nil
+}
+
+// withBody returns a copy of the message with a new body. Everything else, including the attributes and the
+// receipt handle, is kept.
+func withBody(msg *sqs.Message, body string) *sqs.Message {
+	out := *msg
+	out.Body = aws.String(body)
+	out.MD5OfBody = nil
+
+	return &out
+}
@@ -0,0 +1,253 @@
+package sqsdr
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// assertJSONEqual compares two JSON documents ignoring the order of keys
+func assertJSONEqual(t *testing.T, got string, want string) {
+	t.Helper()
+
+	var g, w interface{}
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatalf("%q is not JSON: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("%q is not JSON: %v", want, err)
+	}
+
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// The examples from appendix A of RFC 7386
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+
+		// Nested objects merge instead of replacing each other
+		{`{"a":{"b":1,"c":{"d":2,"e":3}}}`, `{"a":{"c":{"e":null,"f":4}}}`, `{"a":{"b":1,"c":{"d":2,"f":4}}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target+" "+test.patch, func(t *testing.T) {
+			var target, patch interface{}
+			json.Unmarshal([]byte(test.target), &target)
+			json.Unmarshal([]byte(test.patch), &patch)
+
+			got, err := json.Marshal(mergePatch(target, patch))
+			if err != nil {
+				t.Fatalf("could not convert the result to JSON: %v", err)
+			}
+
+			assertJSONEqual(t, string(got), test.want)
+		})
+	}
+}
+
+func TestMergePatchTransformer(t *testing.T) {
+	m, err := NewMergePatchTransformer(`{"status":"retry","debug":null}`)
+	if err != nil {
+		t.Fatalf("NewMergePatchTransformer returned an error: %v", err)
+	}
+
+	msg := &sqs.Message{
+		MessageId:     aws.String("1"),
+		ReceiptHandle: aws.String("handle"),
+		Body:          aws.String(`{"status":"failed","debug":true,"id":7}`),
+		MD5OfBody:     aws.String("md5"),
+	}
+
+	out, err := m.Transform(msg)
+	if err != nil {
+		t.Fatalf("Transform returned an error: %v", err)
+	}
+
+	assertJSONEqual(t, aws.StringValue(out.Body), `{"status":"retry","id":7}`)
+
+	if aws.StringValue(out.ReceiptHandle) != "handle" || out.MD5OfBody != nil {
+		t.Errorf("transformed message has receipt handle %v and MD5 %v", aws.StringValue(out.ReceiptHandle), aws.StringValue(out.MD5OfBody))
+	}
+
+	if aws.StringValue(msg.Body) != `{"status":"failed","debug":true,"id":7}` {
+		t.Errorf("Transform modified the original body: %v", aws.StringValue(msg.Body))
+	}
+
+	_, err = m.Transform(&sqs.Message{MessageId: aws.String("2"), Body: aws.String("not json")})
+	if err == nil {
+		t.Error("Transform patched a body that isn't JSON")
+	}
+
+	_, err = NewMergePatchTransformer(`{"status":`)
+	if err == nil {
+		t.Error("NewMergePatchTransformer accepted a patch that isn't JSON")
+	}
+}
+
+func TestTemplateTransformer(t *testing.T) {
+	msg := &sqs.Message{
+		MessageId:  aws.String("1"),
+		Body:       aws.String(`{"order":{"id":7,"items":["a","b"]}}`),
+		Attributes: map[string]*string{"ApproximateReceiveCount": aws.String("3")},
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+			"blob":   {DataType: aws.String("Binary"), BinaryValue: []byte("x")},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		body     string
+		want     string
+	}{
+		{
+			name:     "body",
+			template: `wrapped: {{.Body}}`,
+			want:     `wrapped: {"order":{"id":7,"items":["a","b"]}}`,
+		},
+		{
+			name:     "json fields",
+			template: `{"id":{{.JSON.order.id}},"first":"{{index .JSON.order.items 0}}"}`,
+			want:     `{"id":7,"first":"a"}`,
+		},
+		{
+			name:     "json function",
+			template: `{{json .JSON.order}}`,
+			want:     `{"id":7,"items":["a","b"]}`,
+		},
+		{
+			// Binary message attributes are left out
+			name:     "attributes",
+			template: `{{.Attributes.ApproximateReceiveCount}} {{.MessageAttributes.tenant}} {{len .MessageAttributes}}`,
+			want:     `3 acme 1`,
+		},
+		{
+			name:     "body that isn't JSON",
+			template: `{{if .JSON}}json{{else}}text: {{.Body}}{{end}}`,
+			body:     "plain text",
+			want:     `text: plain text`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := NewTemplateTransformer(test.template)
+			if err != nil {
+				t.Fatalf("NewTemplateTransformer returned an error: %v", err)
+			}
+
+			in := msg
+			if test.body != "" {
+				in = withBody(msg, test.body)
+			}
+
+			out, err := tmpl.Transform(in)
+			if err != nil {
+				t.Fatalf("Transform returned an error: %v", err)
+			}
+
+			if got := aws.StringValue(out.Body); got != test.want {
+				t.Errorf("body = %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTemplateTransformerErrors(t *testing.T) {
+	msg := &sqs.Message{MessageId: aws.String("1"), Body: aws.String(`{"a":1}`)}
+
+	_, err := NewTemplateTransformer(`{{.Body`)
+	if err == nil {
+		t.Error("NewTemplateTransformer parsed an unterminated action")
+	}
+
+	_, err = NewTemplateTransformer(`{{nope .Body}}`)
+	if err == nil {
+		t.Error("NewTemplateTransformer parsed an unknown function")
+	}
+
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"unknown field", `{{.Missing}}`},
+		{"index into a number", `{{index .JSON.a 3}}`},
+		{"slice past the end", `{{slice .Body 0 100}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := NewTemplateTransformer(test.template)
+			if err != nil {
+				t.Fatalf("NewTemplateTransformer returned an error: %v", err)
+			}
+
+			_, err = tmpl.Transform(msg)
+			if err == nil || !strings.Contains(err.Error(), "could not execute transform template") {
+				t.Errorf("Transform returned %v; want an execution error", err)
+			}
+		})
+	}
+}
+
+func TestParseTransformExpression(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Transformer
+		wantErr bool
+	}{
+		{expr: "jmespath:order.id", want: &JMESPathTransformer{}},
+		{expr: "template:{{.Body}}", want: &TemplateTransformer{}},
+		{expr: `merge:{"a":1}`, want: &MergePatchTransformer{}},
+		{expr: "jmespath:order[", wantErr: true},
+		{expr: "template:{{", wantErr: true},
+		{expr: "merge:{", wantErr: true},
+		{expr: "order.id", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			got, err := ParseTransformExpression(test.expr)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseTransformExpression returned %T; want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseTransformExpression returned an error: %v", err)
+			}
+
+			if reflect.TypeOf(got) != reflect.TypeOf(test.want) {
+				t.Errorf("ParseTransformExpression returned %T; want %T", got, test.want)
+			}
+		})
+	}
+}
@@ -2,27 +2,44 @@ package sqsdr
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
+const (
+	fifoSuffix = ".fifo"
+
+	messageGroupIDAttribute         = "MessageGroupId"
+	messageDeduplicationIDAttribute = "MessageDeduplicationId"
+)
+
 type queueURLer interface {
 	GetQueueUrl(*sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error)
 }
 
-func getQueueURL(queueName string, region string, client queueURLer) (string, error) {
-	output, err := client.GetQueueUrl(
-		&sqs.GetQueueUrlInput{
-			QueueName: aws.String(queueName),
-		},
-	)
+// getQueueURL looks up a queue that may be owned by another account. An empty account ID means the account of
+// the client's credentials.
+func getQueueURL(queueName string, accountID string, region string, client queueURLer) (string, error) {
+	req := &sqs.GetQueueUrlInput{
+		QueueName: aws.String(queueName),
+	}
+
+	if accountID != "" {
+		req.QueueOwnerAWSAccountId = aws.String(accountID)
+	}
+
+	output, err := client.GetQueueUrl(req)
 	if err != nil {
 		aerr, ok := err.(awserr.Error)
 		if ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist {
-			err = fmt.Errorf("could not find source queue with name '%v' in %v", queueName, region)
+			err = fmt.Errorf("could not find queue with name '%v' in %v", queueName, region)
 		}
 
 		return "", err
@@ -31,15 +48,172 @@ func getQueueURL(queueName string, region string, client queueURLer) (string, er
 	return *output.QueueUrl, nil
 }
 
+// ClientConfig describes where an SQS client connects and whose credentials it uses. Leave Profile and RoleARN
+// empty to use the default credential chain.
+type ClientConfig struct {
+	Region string
+
+	// Profile is a profile in the shared AWS config and credentials files
+	Profile string
+
+	// RoleARN is an IAM role to assume with the credentials of the Profile. Use it to reach queues in other accounts.
+	RoleARN string
+
+	// Endpoint optionally points the client at an SQS compatible service such as LocalStack or ElasticMQ,
+	// e.g. http://localhost:9324
+	Endpoint string
+}
+
+// CreateClient returns an initialized SQS client for the AWS region
+func CreateClient(region string) *sqs.SQS {
+	sess := session.New(&aws.Config{Region: aws.String(region)})
+	return sqs.New(sess)
+}
+
+// CreateClientWithConfig returns an initialized SQS client for the region, profile, role, and endpoint in the config
+func CreateClientWithConfig(cfg ClientConfig) (*sqs.SQS, error) {
+	sess, clientConfig, err := newSession(cfg, aws.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	return sqs.New(sess, clientConfig), nil
+}
+
+// CreateS3ClientWithConfig returns an initialized S3 client for the region, profile, role, and endpoint in the
+// config. A custom endpoint uses path style addressing so that S3 compatible services such as MinIO work.
+func CreateS3ClientWithConfig(cfg ClientConfig) (*s3.S3, error) {
+	sess, clientConfig, err := newSession(cfg, aws.Config{S3ForcePathStyle: aws.Bool(cfg.Endpoint != "")})
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.New(sess, clientConfig), nil
+}
+
+// newSession creates a session for the config and returns the config for clients made from it, which has the
+// endpoint and the credentials that assume the role, if there is one
+func newSession(cfg ClientConfig, awsConfig aws.Config) (*session.Session, *aws.Config, error) {
+	awsConfig.Region = aws.String(cfg.Region)
+
+	// The endpoint only belongs to the client. Were it on the session the STS calls to assume the role would go to it.
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		Profile:           cfg.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create AWS session: %v", err)
+	}
+
+	clientConfig := &aws.Config{}
+	if cfg.Endpoint != "" {
+		clientConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+
+	if cfg.RoleARN != "" {
+		clientConfig.Credentials = stscreds.NewCredentials(sess, cfg.RoleARN)
+	}
+
+	return sess, clientConfig, nil
+}
+
 // CreateClientAndValidateQueue takes in an AWS region and a Queue name and returns
 // an intialized SQS client, the Queue URL for a given and an error if one exists.
 func CreateClientAndValidateQueue(region, queueName string) (*sqs.SQS, string, error) {
-	sess := session.New(&aws.Config{Region: aws.String(region)})
-	client := sqs.New(sess)
-	queueURL, err := getQueueURL(queueName, region, client)
+	return CreateClientAndValidateQueueWithConfig(ClientConfig{Region: region}, queueName)
+}
+
+// CreateClientAndValidateQueueWithConfig is like CreateClientAndValidateQueue but also accepts a queue URL or
+// ARN in place of the name so that queues in other accounts can be addressed directly. The region in a queue URL
+// or ARN wins over the region in the config.
+func CreateClientAndValidateQueueWithConfig(cfg ClientConfig, queue string) (*sqs.SQS, string, error) {
+	ref := parseQueueReference(queue)
+	if ref.region != "" {
+		cfg.Region = ref.region
+	}
+
+	client, err := CreateClientWithConfig(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	queueURL, err := getQueueURL(ref.name, ref.accountID, cfg.Region, client)
 	if err != nil {
 		return nil, "", err
 	}
 
 	return client, queueURL, nil
 }
+
+// queueReference is a queue name, URL, or ARN broken into its parts. Only name is guaranteed to be set.
+type queueReference struct {
+	name      string
+	accountID string
+	region    string
+}
+
+// parseQueueReference accepts a queue name, a URL like https://sqs.us-west-2.amazonaws.com/123456789012/my-queue,
+// or an ARN like arn:aws:sqs:us-west-2:123456789012:my-queue
+func parseQueueReference(queue string) queueReference {
+	if strings.HasPrefix(queue, "arn:") {
+		// arn:partition:sqs:region:account-id:queue-name
+		parts := strings.SplitN(queue, ":", 6)
+		if len(parts) == 6 {
+			return queueReference{name: parts[5], accountID: parts[4], region: parts[3]}
+		}
+	}
+
+	if strings.HasPrefix(queue, "https://") || strings.HasPrefix(queue, "http://") {
+		u, err := url.Parse(queue)
+		if err == nil {
+			// The path is /account-id/queue-name
+			parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+			if len(parts) == 2 {
+				return queueReference{name: parts[1], accountID: parts[0], region: regionFromHost(u.Hostname())}
+			}
+		}
+	}
+
+	return queueReference{name: queue}
+}
+
+// regionFromHost pulls the region out of sqs.<region>.amazonaws.com and the legacy <region>.queue.amazonaws.com
+// hosts, including their China and VPC endpoint forms. Anything else, like a local endpoint, has no region.
+func regionFromHost(host string) string {
+	i := strings.Index(host, ".amazonaws.com")
+	if i < 0 {
+		return ""
+	}
+
+	parts := strings.Split(host[:i], ".")
+	for j := 0; j < len(parts)-1; j++ {
+		if parts[j] == "sqs" {
+			return parts[j+1]
+		}
+	}
+
+	if len(parts) >= 2 && parts[len(parts)-1] == "queue" {
+		return parts[len(parts)-2]
+	}
+
+	return ""
+}
+
+// queueNameFromURL returns the last path segment of a Queue URL which is the name of the queue
+func queueNameFromURL(queueURL string) string {
+	split := strings.Split(queueURL, "/")
+	return split[len(split)-1]
+}
+
+// isFIFOQueue reports whether the queue is a FIFO queue. SQS requires FIFO queue names to end in .fifo
+// so the URL is all we need.
+func isFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, fifoSuffix)
+}
+
+// isFIFOMessage reports whether the message was received from a FIFO queue
+func isFIFOMessage(msg *sqs.Message) bool {
+	_, ok := msg.Attributes[messageGroupIDAttribute]
+	return ok
+}
@@ -0,0 +1,99 @@
+package sqsdr
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestParseQueueReference(t *testing.T) {
+	tests := []struct {
+		queue string
+		want  queueReference
+	}{
+		// Names
+		{"my-queue", queueReference{name: "my-queue"}},
+		{"orders.fifo", queueReference{name: "orders.fifo"}},
+
+		// URLs
+		{"https://sqs.us-west-2.amazonaws.com/123456789012/my-queue", queueReference{name: "my-queue", accountID: "123456789012", region: "us-west-2"}},
+		{"https://us-west-2.queue.amazonaws.com/123456789012/my-queue", queueReference{name: "my-queue", accountID: "123456789012", region: "us-west-2"}},
+		{"https://sqs.cn-north-1.amazonaws.com.cn/123456789012/my-queue", queueReference{name: "my-queue", accountID: "123456789012", region: "cn-north-1"}},
+		{"https://sqs.eu-west-1.amazonaws.com/123456789012/orders.fifo/", queueReference{name: "orders.fifo", accountID: "123456789012", region: "eu-west-1"}},
+		{"http://localhost:9324/000000000000/my-queue", queueReference{name: "my-queue", accountID: "000000000000"}},
+
+		// ARNs
+		{"arn:aws:sqs:us-west-2:123456789012:my-queue", queueReference{name: "my-queue", accountID: "123456789012", region: "us-west-2"}},
+		{"arn:aws-cn:sqs:cn-north-1:123456789012:orders.fifo", queueReference{name: "orders.fifo", accountID: "123456789012", region: "cn-north-1"}},
+
+		// Anything malformed is taken to be a name so that GetQueueUrl reports it
+		{"arn:aws:sqs:us-west-2:my-queue", queueReference{name: "arn:aws:sqs:us-west-2:my-queue"}},
+		{"https://sqs.us-west-2.amazonaws.com/my-queue", queueReference{name: "https://sqs.us-west-2.amazonaws.com/my-queue"}},
+		{"https://sqs.us-west-2.amazonaws.com/123456789012/my-queue/extra", queueReference{name: "https://sqs.us-west-2.amazonaws.com/123456789012/my-queue/extra"}},
+		{"https://%zz/123456789012/my-queue", queueReference{name: "https://%zz/123456789012/my-queue"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.queue, func(t *testing.T) {
+			if got := parseQueueReference(test.queue); got != test.want {
+				t.Errorf("parseQueueReference = %+v; want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRegionFromHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"sqs.us-west-2.amazonaws.com", "us-west-2"},
+		{"us-west-2.queue.amazonaws.com", "us-west-2"},
+		{"sqs.cn-north-1.amazonaws.com.cn", "cn-north-1"},
+		{"vpce-0123456789abcdef0-abcdefgh.sqs.us-east-1.vpce.amazonaws.com", "us-east-1"},
+		{"sqs.amazonaws.com", ""},
+		{"queue.amazonaws.com", ""},
+		{"localhost", ""},
+		{"sqs.us-east-1.localhost.localstack.cloud", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			if got := regionFromHost(test.host); got != test.want {
+				t.Errorf("regionFromHost(%q) = %q; want %q", test.host, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCreateClientWithConfigEndpointAndRole(t *testing.T) {
+	cfg := ClientConfig{
+		Region:   "us-west-2",
+		RoleARN:  "arn:aws:iam::123456789012:role/redrive",
+		Endpoint: "http://localhost:9324",
+	}
+
+	sess, clientConfig, err := newSession(cfg, aws.Config{})
+	if err != nil {
+		t.Fatalf("newSession returned an error: %v", err)
+	}
+
+	// STS is called through the session so it must not go to the SQS endpoint
+	if sess.Config.Endpoint != nil {
+		t.Errorf("session endpoint = %v; want the default", aws.StringValue(sess.Config.Endpoint))
+	}
+
+	if clientConfig.Credentials == nil {
+		t.Error("client config does not assume the role")
+	}
+
+	client, err := CreateClientWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("CreateClientWithConfig returned an error: %v", err)
+	}
+
+	if client.Endpoint != cfg.Endpoint {
+		t.Errorf("SQS endpoint = %v; want %v", client.Endpoint, cfg.Endpoint)
+	}
+}